@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/k1LoW/trivy-db-to/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFormat     string
+	diffCacheDir   string
+	diffDBFile     string
+	diffTableNames = drivers.DefaultTableNames()
+	diffSources    []string
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [OLD_TRIVY_DB NEW_TRIVY_DB | DSN]",
+	Short: "report vulnerabilities and advisories added, removed or modified between two generations of Trivy DB",
+	Long: `Report vulnerabilities and advisories added, removed or modified between two generations of Trivy DB.
+
+With two arguments, the trivy.db files are compared. With a DSN, the tables of the datasource are compared
+with Trivy DB in the cache directory (or --db-file) that would be loaded into them.`,
+	SilenceUsage: true,
+	Args:         cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		var (
+			changes []internal.Change
+			err     error
+		)
+		switch {
+		case len(args) == 2:
+			if strings.Contains(args[0], "://") || strings.Contains(args[1], "://") {
+				return errors.New("specify two trivy.db files, or only a DSN to compare with Trivy DB in the cache directory (or --db-file)")
+			}
+			changes, err = internal.DiffDB(ctx, args[0], args[1], diffSources)
+		case strings.Contains(args[0], "://"):
+			dbPath := diffDBFile
+			if dbPath == "" {
+				if diffCacheDir == "" {
+					diffCacheDir = cacheDirPath()
+				}
+				dbPath = internal.TrivyDBPath(diffCacheDir)
+			}
+			changes, err = internal.DiffDSN(ctx, args[0], dbPath, diffTableNames, diffSources)
+		default:
+			return errors.New("specify two trivy.db files, or a DSN to compare with Trivy DB in the cache directory")
+		}
+		if err != nil {
+			return err
+		}
+		return internal.WriteChanges(os.Stdout, changes, diffFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "f", internal.DiffFormatTable, "output format (table|json|markdown)")
+	diffCmd.Flags().StringVarP(&diffCacheDir, "cache-dir", "", "", "cache dir of Trivy DB compared with a DSN")
+	diffCmd.Flags().StringVarP(&diffDBFile, "db-file", "", "", "trivy.db file compared with a DSN instead of the cache")
+	diffCmd.Flags().StringVarP(&diffTableNames.Vulnerabilities, "vulnerabilities-table-name", "", diffTableNames.Vulnerabilities, "Vulnerabilities Table Name")
+	diffCmd.Flags().StringVarP(&diffTableNames.Advisories, "advisory-table-name", "", diffTableNames.Advisories, "Vulnerability Advisories Table Name")
+	diffCmd.Flags().StringArrayVarP(&diffSources, "source", "", nil, "Vulnerability Source (supporting regexp)")
+}
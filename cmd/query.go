@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/k1LoW/trivy-db-to/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	queryFormat     string
+	queryFilter     internal.AdvisoryFilter
+	queryTableNames = drivers.DefaultTableNames()
+)
+
+var queryCmd = &cobra.Command{
+	Use:   "query [DSN]",
+	Short: "look up the advisories of a package or a vulnerability in the tables of the datasource",
+	Long: `Look up the advisories of a package or a vulnerability in the tables of the datasource, joined with the details of the vulnerabilities.
+
+The datasource must be loaded by trivy-db-to into MySQL, PostgreSQL or SQLite.`,
+	Example: `  trivy-db-to query --platform debian --segment 12 --package openssl sqlite:///path/to/file.db
+  trivy-db-to query --cve CVE-2023-0001 --format json mysql://user:password@ip_address:port/dbname`,
+	SilenceUsage: true,
+	Args:         cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		advisories, err := internal.QueryAdvisories(context.Background(), args[0], queryTableNames, queryFilter)
+		if err != nil {
+			return err
+		}
+		return internal.WriteAdvisories(os.Stdout, advisories, queryFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(queryCmd)
+	queryCmd.Flags().StringVarP(&queryFormat, "format", "f", internal.QueryFormatTable, "output format (table|json)")
+	queryCmd.Flags().StringVarP(&queryFilter.Platform, "platform", "", "", "platform of advisories (e.g. debian, npm::GitHub Security Advisory npm)")
+	queryCmd.Flags().StringVarP(&queryFilter.Segment, "segment", "", "", "segment of advisories (e.g. 12)")
+	queryCmd.Flags().StringVarP(&queryFilter.Package, "package", "", "", "package name")
+	queryCmd.Flags().StringVarP(&queryFilter.VulnerabilityID, "cve", "", "", "vulnerability ID (e.g. CVE-2023-0001)")
+	queryCmd.Flags().StringVarP(&queryTableNames.Vulnerabilities, "vulnerabilities-table-name", "", queryTableNames.Vulnerabilities, "Vulnerabilities Table Name")
+	queryCmd.Flags().StringVarP(&queryTableNames.Advisories, "advisory-table-name", "", queryTableNames.Advisories, "Vulnerability Advisories Table Name")
+}
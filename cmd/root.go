@@ -23,8 +23,10 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 
+	"github.com/k1LoW/trivy-db-to/drivers"
 	"github.com/k1LoW/trivy-db-to/internal"
 	"github.com/k1LoW/trivy-db-to/version"
 	"github.com/shibukawa/configdir"
@@ -32,17 +34,38 @@ import (
 )
 
 var (
-	quiet                    bool
-	light                    bool
-	skipInit                 bool
-	skipUpdate               bool
-	cacheDir                 string
-	vulnerabilitiesTableName string
-	advisoryTableName        string
-	dataSourceTableName      string
-	sources                  []string
+	quiet      bool
+	light      bool
+	skipInit   bool
+	skipUpdate bool
+	cacheDir   string
+	dbFile     string
+	dbArchive  string
+	tableNames drivers.TableNames
+	sources    []string
+	loadMode   string
+	registry   internal.RegistryOptions
+	javaDB     bool
+	javaDBFile string
+	javaDBRepo []string
+	driverOpts drivers.Options
+	compress   string
+	vacuum     bool
+	recording  bool
+	history    bool
 )
 
+// registryEnvs are the environment variables used for registry flags that are not set.
+var registryEnvs = map[string]string{
+	"db-repository":      "TRIVY_DB_TO_DB_REPOSITORY",
+	"java-db-repository": "TRIVY_DB_TO_JAVA_DB_REPOSITORY",
+	"registry-username":  "TRIVY_DB_TO_REGISTRY_USERNAME",
+	"registry-password":  "TRIVY_DB_TO_REGISTRY_PASSWORD",
+	"docker-config":      "TRIVY_DB_TO_DOCKER_CONFIG",
+	"insecure":           "TRIVY_DB_TO_INSECURE",
+	"ca-cert":            "TRIVY_DB_TO_CA_CERT",
+}
+
 var rootCmd = &cobra.Command{
 	Use:          "trivy-db-to [DSN]",
 	Short:        "trivy-db-to is a tool for migrating/converting vulnerability information from Trivy DB to other datasource",
@@ -52,28 +75,101 @@ var rootCmd = &cobra.Command{
 	Args:         cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := context.Background()
-		if cacheDir == "" {
-			cacheDir = cacheDirPath()
-		}
 		dsn := args[0]
-		if err := internal.FetchTrivyDB(ctx, cacheDir, light, quiet, skipUpdate); err != nil {
-			return err
+		if !recording {
+			tableNames.Changes = ""
+		}
+		if !history {
+			tableNames.VulnHistory = ""
+			tableNames.AdvisoryHistory = ""
+		}
+		if compress != "" {
+			if _, err := internal.CompressedPath(dsn, compress); err != nil {
+				return err
+			}
+		}
+		var dbPath string
+		switch {
+		case dbFile != "":
+			dbPath = dbFile
+		case dbArchive != "":
+			dir, err := os.MkdirTemp("", "trivy-db-to")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			dbPath, err = internal.ExtractTrivyDB(dbArchive, dir)
+			if err != nil {
+				return err
+			}
+		default:
+			if cacheDir == "" {
+				cacheDir = cacheDirPath()
+			}
+			if err := setFlagsFromEnv(cmd, registryEnvs); err != nil {
+				return err
+			}
+			if err := internal.FetchTrivyDB(ctx, cacheDir, light, quiet, skipUpdate, registry); err != nil {
+				return err
+			}
+			dbPath = internal.TrivyDBPath(cacheDir)
 		}
 
 		if !skipInit {
-			if err := internal.InitDB(ctx, dsn, vulnerabilitiesTableName, advisoryTableName, dataSourceTableName); err != nil {
+			if err := internal.InitDB(ctx, dsn, tableNames); err != nil {
 				return err
 			}
 		}
 
-		if err := internal.UpdateDB(ctx, cacheDir, dsn, vulnerabilitiesTableName, advisoryTableName, sources, dataSourceTableName); err != nil {
+		if err := internal.UpdateDB(ctx, dbPath, dsn, tableNames, sources, loadMode, driverOpts); err != nil {
 			return err
 		}
 
+		if javaDB || javaDBFile != "" {
+			if err := updateJavaDB(ctx, cmd, dsn); err != nil {
+				return err
+			}
+		}
+
+		if vacuum || driverOpts.SQLiteCompact {
+			if err := internal.VacuumSQLite(ctx, dsn); err != nil {
+				return err
+			}
+		}
+
+		if compress != "" {
+			if _, err := internal.CompressSQLite(dsn, compress); err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 }
 
+func updateJavaDB(ctx context.Context, cmd *cobra.Command, dsn string) error {
+	javaDBPath := javaDBFile
+	if javaDBPath == "" {
+		if cacheDir == "" {
+			cacheDir = cacheDirPath()
+		}
+		if err := setFlagsFromEnv(cmd, registryEnvs); err != nil {
+			return err
+		}
+		opts := registry
+		opts.Repositories = javaDBRepo
+		if err := internal.FetchJavaDB(ctx, cacheDir, quiet, skipUpdate, opts); err != nil {
+			return err
+		}
+		javaDBPath = internal.JavaDBPath(cacheDir)
+	}
+	if !skipInit {
+		if err := internal.InitJavaDB(ctx, dsn, tableNames); err != nil {
+			return err
+		}
+	}
+	return internal.UpdateJavaDB(ctx, javaDBPath, dsn, tableNames, driverOpts)
+}
+
 func Execute() {
 	rootCmd.SetOut(os.Stdout)
 	rootCmd.SetErr(os.Stderr)
@@ -88,10 +184,62 @@ func init() {
 	rootCmd.Flags().BoolVarP(&skipInit, "skip-init-db", "", false, "skip initializing target datasource")
 	rootCmd.Flags().BoolVarP(&skipUpdate, "skip-update", "", false, "skip updating Trivy DB")
 	rootCmd.Flags().StringVarP(&cacheDir, "cache-dir", "", "", "cache dir")
-	rootCmd.Flags().StringVarP(&vulnerabilitiesTableName, "vulnerabilities-table-name", "", "vulnerabilities", "Vulnerabilities Table Name")
-	rootCmd.Flags().StringVarP(&advisoryTableName, "advisory-table-name", "", "vulnerability_advisories", "Vulnerability Advisories Table Name")
-	rootCmd.Flags().StringVarP(&dataSourceTableName, "data-source-table-name", "", "data_source", "Data Source Table Name")
+	rootCmd.Flags().StringVarP(&dbFile, "db-file", "", "", "import a local trivy.db file without fetching Trivy DB")
+	rootCmd.Flags().StringVarP(&dbArchive, "db-archive", "", "", "import a local Trivy DB tarball (db.tar.gz or OCI image layout) without fetching Trivy DB")
+	rootCmd.MarkFlagsMutuallyExclusive("db-file", "db-archive")
+	rootCmd.Flags().StringSliceVarP(&registry.Repositories, "db-repository", "", []string{internal.DefaultDBRepository}, "Trivy DB repositories, tried in order until a download succeeds ($TRIVY_DB_TO_DB_REPOSITORY)")
+	rootCmd.Flags().StringVarP(&registry.Username, "registry-username", "", "", "registry username ($TRIVY_DB_TO_REGISTRY_USERNAME)")
+	rootCmd.Flags().StringVarP(&registry.Password, "registry-password", "", "", "registry password ($TRIVY_DB_TO_REGISTRY_PASSWORD)")
+	rootCmd.Flags().StringVarP(&registry.DockerConfig, "docker-config", "", "", "directory of Docker config.json for registry credentials ($TRIVY_DB_TO_DOCKER_CONFIG)")
+	rootCmd.Flags().BoolVarP(&registry.Insecure, "insecure", "", false, "skip TLS certificate verification of the registry ($TRIVY_DB_TO_INSECURE)")
+	rootCmd.Flags().StringVarP(&registry.CACert, "ca-cert", "", "", "PEM file of CA certificates for the registry ($TRIVY_DB_TO_CA_CERT)")
+	rootCmd.Flags().BoolVarP(&javaDB, "java-db", "", false, "also import Trivy Java DB")
+	rootCmd.Flags().StringVarP(&javaDBFile, "java-db-file", "", "", "import a local trivy-java.db file without fetching Trivy Java DB (implies --java-db)")
+	rootCmd.Flags().StringSliceVarP(&javaDBRepo, "java-db-repository", "", []string{internal.DefaultJavaDBRepository}, "Trivy Java DB repositories, tried in order until a download succeeds ($TRIVY_DB_TO_JAVA_DB_REPOSITORY)")
+	defaultTableNames := drivers.DefaultTableNames()
+	rootCmd.Flags().StringVarP(&tableNames.Vulnerabilities, "vulnerabilities-table-name", "", defaultTableNames.Vulnerabilities, "Vulnerabilities Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.Advisories, "advisory-table-name", "", defaultTableNames.Advisories, "Vulnerability Advisories Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.DataSource, "data-source-table-name", "", defaultTableNames.DataSource, "Data Source Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.Metadata, "metadata-table-name", "", defaultTableNames.Metadata, "Metadata Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.Changes, "changes-table-name", "", defaultTableNames.Changes, "Vulnerability Changes Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.VulnHistory, "vulnerabilities-history-table-name", "", defaultTableNames.VulnHistory, "Vulnerabilities History Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.AdvisoryHistory, "advisory-history-table-name", "", defaultTableNames.AdvisoryHistory, "Vulnerability Advisories History Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.CVSS, "cvss-table-name", "", defaultTableNames.CVSS, "Vulnerability CVSS Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.References, "references-table-name", "", defaultTableNames.References, "Vulnerability References Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.CWEs, "cwes-table-name", "", defaultTableNames.CWEs, "Vulnerability CWEs Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.JavaArtifacts, "java-artifacts-table-name", "", defaultTableNames.JavaArtifacts, "Java Artifacts Table Name")
+	rootCmd.Flags().StringVarP(&tableNames.JavaIndices, "java-indices-table-name", "", defaultTableNames.JavaIndices, "Java Indices Table Name")
 	rootCmd.Flags().StringArrayVarP(&sources, "source", "", nil, "Vulnerability Source (supporting regexp)")
+	rootCmd.Flags().StringVarP(&loadMode, "load-mode", "", internal.LoadModeSwap, "load mode (swap|incremental)")
+	rootCmd.Flags().BoolVarP(&recording, "record-changes", "", false, "append the advisories added, removed or modified by the run to the changes table (MySQL, PostgreSQL and SQLite only)")
+	rootCmd.Flags().BoolVarP(&history, "keep-history", "", false, "keep the history of vulnerabilities and advisories with valid_from/valid_to in the history tables (MySQL, PostgreSQL and SQLite only)")
+	rootCmd.Flags().BoolVarP(&driverOpts.MySQLLocalInfile, "mysql-local-infile", "", false, "load rows into MySQL with LOAD DATA LOCAL INFILE, falling back to INSERT if the server disallows it")
+	rootCmd.Flags().BoolVarP(&vacuum, "sqlite-vacuum", "", false, "rebuild the SQLite database file with VACUUM after loading")
+	rootCmd.Flags().BoolVarP(&driverOpts.SQLiteCompact, "sqlite-compact", "", false, "write a compact SQLite database for distribution (implies --sqlite-vacuum)")
+	rootCmd.Flags().Int64VarP(&driverOpts.ParquetRowGroupSize, "parquet-row-group-size", "", 0, "maximum number of rows of a row group of Parquet files (0 means the default of parquet-go)")
+	rootCmd.Flags().BoolVarP(&driverOpts.ParquetPartitionByPlatform, "parquet-partition-by-platform", "", false, "write the advisories into a Parquet file per platform (Hive-style platform=<platform> directories)")
+	rootCmd.Flags().StringVarP(&driverOpts.CSVDelimiter, "csv-delimiter", "", ",", "field delimiter of CSV files (\\t for a tab)")
+	rootCmd.Flags().BoolVarP(&driverOpts.CSVQuoteAll, "csv-quote-all", "", false, "quote every field of CSV files")
+	rootCmd.Flags().BoolVarP(&driverOpts.CSVNoHeader, "csv-no-header", "", false, "omit the header row of CSV files")
+	rootCmd.Flags().BoolVarP(&driverOpts.CSVGzip, "csv-gzip", "", false, "compress CSV files with gzip")
+	rootCmd.Flags().BoolVarP(&driverOpts.CSVNormalized, "csv-normalized", "", false, "add the columns extracted from the JSON values (severity, fixed_version, ...) to CSV files")
+	rootCmd.Flags().StringSliceVarP(&driverOpts.CSVColumns, "csv-columns", "", nil, "columns written to CSV files (tables without any of the columns are not written)")
+	rootCmd.Flags().StringVarP(&driverOpts.ElasticsearchURL, "elasticsearch-url", "", "", "also post the bulk files to Elasticsearch (or OpenSearch) at the URL")
+	rootCmd.Flags().StringVarP(&compress, "compress", "", "", "compress the SQLite database after loading (zstd|gzip) and write a SHA-256 checksum file next to it")
+}
+
+// setFlagsFromEnv sets the flags that are not set on the command line from the environment variables in envs.
+func setFlagsFromEnv(cmd *cobra.Command, envs map[string]string) error {
+	for name, env := range envs {
+		v, ok := os.LookupEnv(env)
+		if !ok || cmd.Flags().Changed(name) {
+			continue
+		}
+		if err := cmd.Flags().Set(name, v); err != nil {
+			return fmt.Errorf("invalid %s: %w", env, err)
+		}
+	}
+	return nil
 }
 
 func cacheDirPath() string {
@@ -0,0 +1,77 @@
+package drivers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aquasecurity/trivy-db/pkg/types"
+)
+
+// statuses are the names of the Status values of newer Trivy DB advisories.
+var statuses = []string{
+	"unknown",
+	"not_affected",
+	"affected",
+	"fixed",
+	"under_investigation",
+	"will_not_fix",
+	"fix_deferred",
+	"end_of_life",
+}
+
+// Advisory holds the columns extracted from a value of an advisory bucket.
+type Advisory struct {
+	FixedVersion       string
+	AffectedVersion    string
+	VulnerableVersions []string
+	PatchedVersions    []string
+	UnaffectedVersions []string
+	Status             string
+	Severity           string
+}
+
+// ParseAdvisory extracts the columns from a value of an advisory bucket.
+func ParseAdvisory(value []byte) (Advisory, error) {
+	var a struct {
+		types.Advisory
+		Status int `json:",omitempty"`
+	}
+	if err := json.Unmarshal(value, &a); err != nil {
+		return Advisory{}, err
+	}
+	adv := Advisory{
+		FixedVersion:       a.FixedVersion,
+		AffectedVersion:    a.AffectedVersion,
+		VulnerableVersions: nonNil(a.VulnerableVersions),
+		PatchedVersions:    nonNil(a.PatchedVersions),
+		UnaffectedVersions: nonNil(a.UnaffectedVersions),
+	}
+	switch {
+	case a.Status > 0 && a.Status < len(statuses):
+		adv.Status = statuses[a.Status]
+	case a.State != "":
+		adv.Status = a.State
+	case a.FixedVersion != "":
+		adv.Status = statuses[3]
+	}
+	if a.Severity != types.SeverityUnknown && int(a.Severity) < len(types.SeverityNames) {
+		adv.Severity = a.Severity.String()
+	}
+	return adv, nil
+}
+
+// JSONArray returns s as a JSON array. Version constraints such as "< 1.0.0" are kept unescaped.
+func JSONArray(s []string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(nonNil(s))
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
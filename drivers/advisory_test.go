@@ -0,0 +1,32 @@
+package drivers
+
+import "testing"
+
+func TestParseAdvisory(t *testing.T) {
+	tests := []struct {
+		in                 string
+		wantStatus         string
+		wantSeverity       string
+		wantVulnerableJSON string
+	}{
+		{`{"Status":3,"State":"affected","Severity":3}`, "fixed", "HIGH", "[]"},
+		{`{"State":"Will not fix"}`, "Will not fix", "", "[]"},
+		{`{"FixedVersion":"3.0.9-1"}`, "fixed", "", "[]"},
+		{`{"PatchedVersions":["4.17.21"],"VulnerableVersions":["< 4.17.21"]}`, "", "", `["< 4.17.21"]`},
+	}
+	for _, tt := range tests {
+		got, err := ParseAdvisory([]byte(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tt.wantStatus {
+			t.Errorf("ParseAdvisory(%s) Status = %s, want %s", tt.in, got.Status, tt.wantStatus)
+		}
+		if got.Severity != tt.wantSeverity {
+			t.Errorf("ParseAdvisory(%s) Severity = %s, want %s", tt.in, got.Severity, tt.wantSeverity)
+		}
+		if j := JSONArray(got.VulnerableVersions); j != tt.wantVulnerableJSON {
+			t.Errorf("ParseAdvisory(%s) VulnerableVersions = %s, want %s", tt.in, j, tt.wantVulnerableJSON)
+		}
+	}
+}
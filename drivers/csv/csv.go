@@ -0,0 +1,483 @@
+package csv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const (
+	ext     = ".csv"
+	gzipExt = ".gz"
+)
+
+var (
+	vulnerabilitiesColumns           = []string{"vulnerability_id", "value"}
+	vulnerabilitiesNormalizedColumns = []string{"title", "severity", "published_date", "last_modified_date", "cvss_v2_vector", "cvss_v2_score", "cvss_v3_vector", "cvss_v3_score"}
+	advisoryColumns                  = []string{"vulnerability_id", "platform", "segment", "package", "value"}
+	advisoryNormalizedColumns        = []string{"fixed_version", "affected_version", "vulnerable_versions", "patched_versions", "unaffected_versions", "status", "severity"}
+	dataSourceColumns                = []string{"source_key", "value"}
+	dataSourceNormalizedColumns      = []string{"source_id", "source_name", "source_url"}
+	cvssColumns                      = []string{"vulnerability_id", "source", "v2_vector", "v2_score", "v3_vector", "v3_score", "v4_vector", "v4_score"}
+	referencesColumns                = []string{"vulnerability_id", "url"}
+	cwesColumns                      = []string{"vulnerability_id", "cwe_id"}
+	javaArtifactsColumns             = []string{"id", "group_id", "artifact_id"}
+	javaIndicesColumns               = []string{"artifact_id", "version", "sha1", "archive_type"}
+	metadataColumns                  = []string{"trivy_db_version", "updated_at", "next_update", "downloaded_at", "trivy_db_to_version", "row_counts", "created_at"}
+)
+
+// Csv writes each table into a CSV file <table>.csv (or <table>.csv.gz) in a directory.
+// The files of a load are written under shadow names and renamed on swap.
+type Csv struct {
+	dir                      string
+	vulnerabilitiesTableName string
+	advisoryTableName        string
+	dataSourceTableName      string
+	metadataTableName        string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	delimiter                rune
+	quoteAll                 bool
+	header                   bool
+	gzip                     bool
+	normalized               bool
+	columns                  []string
+	// files holds the open files of the running load by table name
+	files map[string]*file
+}
+
+// New return *Csv
+func New(dir string, tableNames drivers.TableNames, opts drivers.Options) (*Csv, error) {
+	if dir == "" {
+		return nil, errors.New("no directory to write CSV files to")
+	}
+	delimiter, err := parseDelimiter(opts.CSVDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	return &Csv{
+		dir:                      dir,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+		delimiter:                delimiter,
+		quoteAll:                 opts.CSVQuoteAll,
+		header:                   !opts.CSVNoHeader,
+		gzip:                     opts.CSVGzip,
+		normalized:               opts.CSVNormalized,
+		columns:                  opts.CSVColumns,
+		files:                    map[string]*file{},
+	}, nil
+}
+
+// parseDelimiter returns the delimiter of s. `\t` is accepted for a tab, which is hard to pass on a command line.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case "":
+		return ',', nil
+	case `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid CSV delimiter '%s'", s)
+	}
+	return r, nil
+}
+
+type table struct {
+	name    string
+	columns []string
+}
+
+func (m *Csv) tables() []table {
+	return []table{
+		{m.vulnerabilitiesTableName, m.withNormalized(vulnerabilitiesColumns, vulnerabilitiesNormalizedColumns)},
+		{m.advisoryTableName, m.withNormalized(advisoryColumns, advisoryNormalizedColumns)},
+		{m.dataSourceTableName, m.withNormalized(dataSourceColumns, dataSourceNormalizedColumns)},
+		{m.cvssTableName, cvssColumns},
+		{m.referencesTableName, referencesColumns},
+		{m.cwesTableName, cwesColumns},
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Csv) javaDBTables() []table {
+	return []table{
+		{m.javaArtifactsTableName, javaArtifactsColumns},
+		{m.javaIndicesTableName, javaIndicesColumns},
+	}
+}
+
+func (m *Csv) withNormalized(columns, normalized []string) []string {
+	if !m.normalized {
+		return columns
+	}
+	return append(slices.Clip(columns), normalized...)
+}
+
+// selected returns the indices of the columns to write. Tables without any selected column are not written.
+func (m *Csv) selected(columns []string) []int {
+	var indices []int
+	for i, c := range columns {
+		if len(m.columns) == 0 || slices.Contains(m.columns, c) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func (m *Csv) Migrate(ctx context.Context) error {
+	return os.MkdirAll(m.dir, 0755) //nolint:gosec
+}
+
+func (m *Csv) MigrateJavaDB(ctx context.Context) error {
+	return os.MkdirAll(m.dir, 0755) //nolint:gosec
+}
+
+func (m *Csv) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.tables())
+}
+
+// SwapTables closes the files of the load and renames them to the names of the tables.
+func (m *Csv) SwapTables(ctx context.Context) error {
+	return m.swapTables(m.tables())
+}
+
+func (m *Csv) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.tables())
+}
+
+func (m *Csv) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.javaDBTables())
+}
+
+func (m *Csv) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(m.javaDBTables())
+}
+
+func (m *Csv) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.javaDBTables())
+}
+
+func (m *Csv) createShadowTables(tables []table) error {
+	if err := m.dropShadowTables(tables); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		indices := m.selected(t.columns)
+		if len(indices) == 0 {
+			continue
+		}
+		f, err := m.open(m.shadowPath(t.name), false, indices)
+		if err != nil {
+			return err
+		}
+		m.files[t.name] = f
+		if m.header {
+			if err := f.write(t.columns); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Csv) swapTables(tables []table) error {
+	for _, t := range tables {
+		f, ok := m.files[t.name]
+		if !ok {
+			continue
+		}
+		delete(m.files, t.name)
+		if err := f.close(); err != nil {
+			return err
+		}
+		if err := os.Rename(m.shadowPath(t.name), m.path(t.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Csv) dropShadowTables(tables []table) error {
+	var errs []error
+	for _, t := range tables {
+		if f, ok := m.files[t.name]; ok {
+			delete(m.files, t.name)
+			errs = append(errs, f.close())
+		}
+		if err := os.Remove(m.shadowPath(t.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Csv) path(table string) string {
+	if m.gzip {
+		return filepath.Join(m.dir, table+ext+gzipExt)
+	}
+	return filepath.Join(m.dir, table+ext)
+}
+
+func (m *Csv) shadowPath(table string) string {
+	return m.path(drivers.ShadowName(table))
+}
+
+// write writes rows to the file of table. Rows of tables without any selected column are discarded.
+func (m *Csv) write(table string, rows [][]string) error {
+	f, ok := m.files[table]
+	if !ok {
+		return nil
+	}
+	for _, r := range rows {
+		if err := f.write(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Csv) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	var rows [][]string
+	for _, vuln := range vulns {
+		r := []string{string(vuln[0]), string(vuln[1])}
+		if m.normalized {
+			v, err := drivers.ParseVulnerability(vuln[1])
+			if err != nil {
+				return err
+			}
+			r = append(r, v.Title, v.Severity, nullTime(v.PublishedDate), nullTime(v.LastModifiedDate),
+				v.CVSSV2Vector, nullFloat(v.CVSSV2Score), v.CVSSV3Vector, nullFloat(v.CVSSV3Score))
+		}
+		rows = append(rows, r)
+	}
+	return m.write(m.vulnerabilitiesTableName, rows)
+}
+
+func (m *Csv) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	var rows [][]string
+	for _, secAdvisory := range secAdvisories {
+		r := []string{string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]), string(secAdvisory[4])}
+		if m.normalized {
+			a, err := drivers.ParseAdvisory(secAdvisory[4])
+			if err != nil {
+				return err
+			}
+			r = append(r, a.FixedVersion, a.AffectedVersion, drivers.JSONArray(a.VulnerableVersions),
+				drivers.JSONArray(a.PatchedVersions), drivers.JSONArray(a.UnaffectedVersions), a.Status, a.Severity)
+		}
+		rows = append(rows, r)
+	}
+	return m.write(m.advisoryTableName, rows)
+}
+
+func (m *Csv) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	var rows [][]string
+	for _, dataSource := range dataSources {
+		r := []string{string(dataSource[0]), string(dataSource[1])}
+		if m.normalized {
+			var item drivers.DataSource
+			if err := json.Unmarshal(dataSource[1], &item); err != nil {
+				return err
+			}
+			r = append(r, item.ID, item.Name, item.URL)
+		}
+		rows = append(rows, r)
+	}
+	return m.write(m.dataSourceTableName, rows)
+}
+
+func (m *Csv) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var rows [][]string
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []string{string(c[0]), string(c[1]), v.V2Vector, nullFloat(v.V2Score),
+			v.V3Vector, nullFloat(v.V3Score), v.V4Vector, nullFloat(v.V4Score)})
+	}
+	return m.write(m.cvssTableName, rows)
+}
+
+func (m *Csv) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return m.write(m.referencesTableName, stringRows(references))
+}
+
+func (m *Csv) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return m.write(m.cwesTableName, stringRows(cwes))
+}
+
+func (m *Csv) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	return m.write(m.javaArtifactsTableName, stringRows(artifacts))
+}
+
+func (m *Csv) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	return m.write(m.javaIndicesTableName, stringRows(indices))
+}
+
+// InsertMetadata appends the metadata of the run to the metadata file. The header is written when the file is created.
+// The metadata is written with all columns regardless of the selected columns.
+func (m *Csv) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
+	if err != nil {
+		return err
+	}
+	path := m.path(m.metadataTableName)
+	_, err = os.Stat(path)
+	exists := err == nil
+	var indices []int
+	for i := range metadataColumns {
+		indices = append(indices, i)
+	}
+	f, err := m.open(path, true, indices)
+	if err != nil {
+		return err
+	}
+	if m.header && !exists {
+		if err := f.write(metadataColumns); err != nil {
+			_ = f.close()
+			return err
+		}
+	}
+	if err := f.write([]string{strconv.Itoa(metadata.Version), nullTime(drivers.NullTime(metadata.UpdatedAt)), nullTime(drivers.NullTime(metadata.NextUpdate)),
+		nullTime(drivers.NullTime(metadata.DownloadedAt)), metadata.TrivyDBToVersion, string(counts), time.Now().UTC().Format(time.RFC3339Nano)}); err != nil {
+		_ = f.close()
+		return err
+	}
+	return f.close()
+}
+
+// file is a CSV file written by a load.
+type file struct {
+	f         *os.File
+	gz        *gzip.Writer
+	w         *bufio.Writer
+	delimiter rune
+	quoteAll  bool
+	// indices are the indices of the columns written
+	indices []int
+}
+
+// open opens the file at path. A gzip member is appended to an existing file when appending, which gzip readers read as one stream.
+func (m *Csv) open(path string, appending bool, indices []int) (*file, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0644) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	cf := &file{f: f, delimiter: m.delimiter, quoteAll: m.quoteAll, indices: indices}
+	var w io.Writer = f
+	if m.gzip {
+		cf.gz = gzip.NewWriter(f)
+		w = cf.gz
+	}
+	cf.w = bufio.NewWriter(w)
+	return cf, nil
+}
+
+// write writes the selected fields of record as a line terminated by CRLF as RFC 4180.
+func (f *file) write(record []string) error {
+	for i, idx := range f.indices {
+		if i > 0 {
+			if _, err := f.w.WriteRune(f.delimiter); err != nil {
+				return err
+			}
+		}
+		if err := f.writeField(record[idx]); err != nil {
+			return err
+		}
+	}
+	_, err := f.w.WriteString("\r\n")
+	return err
+}
+
+func (f *file) writeField(s string) error {
+	if !f.quoteAll && !f.needsQuotes(s) {
+		_, err := f.w.WriteString(s)
+		return err
+	}
+	if err := f.w.WriteByte('"'); err != nil {
+		return err
+	}
+	if _, err := f.w.WriteString(strings.ReplaceAll(s, `"`, `""`)); err != nil {
+		return err
+	}
+	return f.w.WriteByte('"')
+}
+
+// needsQuotes reports whether s must be quoted. Leading spaces are quoted because some readers trim them.
+func (f *file) needsQuotes(s string) bool {
+	if s == "" {
+		return false
+	}
+	return strings.ContainsRune(s, f.delimiter) || strings.ContainsAny(s, "\"\r\n") || s[0] == ' ' || s[0] == '\t'
+}
+
+func (f *file) close() error {
+	err := f.w.Flush()
+	if f.gz != nil {
+		if gerr := f.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func stringRows(rows [][][]byte) [][]string {
+	var srows [][]string
+	for _, r := range rows {
+		sr := make([]string, len(r))
+		for i, c := range r {
+			sr[i] = string(c)
+		}
+		srows = append(srows, sr)
+	}
+	return srows
+}
+
+// nullTime returns t in RFC 3339, or an empty field for NULL.
+func nullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339Nano)
+}
+
+func nullFloat(f sql.NullFloat64) string {
+	if !f.Valid {
+		return ""
+	}
+	return strconv.FormatFloat(f.Float64, 'f', -1, 64)
+}
@@ -0,0 +1,56 @@
+package csv
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestInsertVulnAdvisory(t *testing.T) {
+	tests := []struct {
+		opts drivers.Options
+		want string
+	}{
+		{
+			drivers.Options{},
+			"vulnerability_id,platform,segment,package,value\r\n" +
+				"CVE-2023-00002,debian,12,openssl,\"{\"\"FixedVersion\"\":\"\"3.0.9-1\"\",\"\"Severity\"\":3}\"\r\n",
+		},
+		{
+			drivers.Options{CSVDelimiter: ";", CSVNormalized: true, CSVColumns: []string{"vulnerability_id", "package", "fixed_version", "severity"}},
+			"vulnerability_id;package;fixed_version;severity\r\n" +
+				"CVE-2023-00002;openssl;3.0.9-1;HIGH\r\n",
+		},
+		{
+			drivers.Options{CSVQuoteAll: true, CSVNoHeader: true, CSVColumns: []string{"vulnerability_id", "segment"}},
+			"\"CVE-2023-00002\",\"12\"\r\n",
+		},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		dir := t.TempDir()
+		m, err := New(dir, drivers.DefaultTableNames(), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte("debian"), []byte("12"), []byte("openssl"), []byte(`{"FixedVersion":"3.0.9-1","Severity":3}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "vulnerability_advisories.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
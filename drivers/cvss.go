@@ -0,0 +1,64 @@
+package drivers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+)
+
+// CVSS holds the columns extracted from a per-source entry of the CVSS map of a vulnerability.
+type CVSS struct {
+	V2Vector string
+	V2Score  sql.NullFloat64
+	V3Vector string
+	V3Score  sql.NullFloat64
+	V4Vector string
+	V4Score  sql.NullFloat64
+}
+
+// CVSSRows returns rows of [vulnerability_id, source, value] for the CVSS map of each row of
+// [vulnerability_id, value] in the vulnerability bucket, in source order.
+func CVSSRows(vulns [][][]byte) ([][][]byte, error) {
+	var rows [][][]byte
+	for _, vuln := range vulns {
+		var v struct {
+			CVSS map[string]json.RawMessage
+		}
+		if err := json.Unmarshal(vuln[1], &v); err != nil {
+			return nil, err
+		}
+		var sources []string
+		for s := range v.CVSS {
+			sources = append(sources, s)
+		}
+		sort.Strings(sources)
+		for _, s := range sources {
+			rows = append(rows, [][]byte{vuln[0], []byte(s), v.CVSS[s]})
+		}
+	}
+	return rows, nil
+}
+
+// ParseCVSS extracts the columns from a value of CVSSRows.
+// V4 is decoded here because types.CVSS of the vendored trivy-db predates it.
+func ParseCVSS(value []byte) (CVSS, error) {
+	var c struct {
+		V2Vector  string
+		V2Score   float64
+		V3Vector  string
+		V3Score   float64
+		V40Vector string
+		V40Score  float64
+	}
+	if err := json.Unmarshal(value, &c); err != nil {
+		return CVSS{}, err
+	}
+	return CVSS{
+		V2Vector: c.V2Vector,
+		V2Score:  sql.NullFloat64{Float64: c.V2Score, Valid: c.V2Vector != ""},
+		V3Vector: c.V3Vector,
+		V3Score:  sql.NullFloat64{Float64: c.V3Score, Valid: c.V3Vector != ""},
+		V4Vector: c.V40Vector,
+		V4Score:  sql.NullFloat64{Float64: c.V40Score, Valid: c.V40Vector != ""},
+	}, nil
+}
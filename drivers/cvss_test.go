@@ -0,0 +1,34 @@
+package drivers
+
+import "testing"
+
+func TestCVSSRows(t *testing.T) {
+	vulns := [][][]byte{
+		{[]byte("CVE-2023-0001"), []byte(`{"CVSS":{"redhat":{"V3Vector":"CVSS:3.1/AV:L","V3Score":5.5},"nvd":{"V2Vector":"AV:N","V2Score":7.5},"ghsa":{"V40Vector":"CVSS:4.0/AV:N","V40Score":9.3}}}`)},
+		{[]byte("CVE-2023-0002"), []byte(`{}`)},
+	}
+	rows, err := CVSSRows(vulns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ghsa", "nvd", "redhat"}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i, r := range rows {
+		if string(r[0]) != "CVE-2023-0001" || string(r[1]) != want[i] {
+			t.Errorf("row %d = [%s, %s], want [CVE-2023-0001, %s]", i, r[0], r[1], want[i])
+		}
+	}
+
+	c, err := ParseCVSS(rows[0][2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.V4Vector != "CVSS:4.0/AV:N" || !c.V4Score.Valid || c.V4Score.Float64 != 9.3 {
+		t.Errorf("ParseCVSS(%s) V4 = %s, %v", rows[0][2], c.V4Vector, c.V4Score)
+	}
+	if c.V2Score.Valid || c.V3Score.Valid {
+		t.Errorf("ParseCVSS(%s) V2Score = %v, V3Score = %v, want NULL", rows[0][2], c.V2Score, c.V3Score)
+	}
+}
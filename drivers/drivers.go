@@ -1,15 +1,149 @@
 package drivers
 
-import "context"
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	shadowSuffix = "_new"
+	oldSuffix    = "_old"
+)
 
 type Driver interface {
 	Migrate(ctx context.Context) error
 
+	// CreateShadowTables creates empty shadow tables. Inserts after this call are written to the shadow tables.
+	CreateShadowTables(ctx context.Context) error
 	InsertVuln(ctx context.Context, vulns [][][]byte) error
 	InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error
+	// InsertVulnCVSS inserts rows of CVSSRows.
+	InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error
+	// InsertVulnReferences inserts rows of ReferenceRows.
+	InsertVulnReferences(ctx context.Context, references [][][]byte) error
+	// InsertVulnCWEs inserts rows of CWERows.
+	InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error
 
 	InsertDataSource(ctx context.Context, dataSources [][][]byte) error
-	TruncateVulns(ctx context.Context) error
-	TruncateVulnAdvisories(ctx context.Context) error
-	TruncateDataSource(ctx context.Context) error
+	// SwapTables atomically replaces the tables with the shadow tables and drops the previous generation.
+	SwapTables(ctx context.Context) error
+	// DropShadowTables drops the shadow tables left by an unfinished load.
+	DropShadowTables(ctx context.Context) error
+
+	InsertMetadata(ctx context.Context, metadata Metadata) error
+}
+
+// JavaDBLoader is a Driver that can load the tables of Trivy Java DB.
+type JavaDBLoader interface {
+	Driver
+
+	// MigrateJavaDB creates the tables of Trivy Java DB.
+	MigrateJavaDB(ctx context.Context) error
+	// CreateJavaDBShadowTables creates empty shadow tables of Trivy Java DB. Inserts after this call are written to them.
+	CreateJavaDBShadowTables(ctx context.Context) error
+	// InsertJavaArtifacts inserts rows of [id, group_id, artifact_id] of the artifacts table of Trivy Java DB.
+	InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error
+	// InsertJavaIndices inserts rows of [artifact_id, version, sha1, archive_type] of the indices table of Trivy Java DB.
+	InsertJavaIndices(ctx context.Context, indices [][][]byte) error
+	// SwapJavaDBTables atomically replaces the tables of Trivy Java DB with the shadow tables.
+	SwapJavaDBTables(ctx context.Context) error
+	// DropJavaDBShadowTables drops the shadow tables of Trivy Java DB left by an unfinished load.
+	DropJavaDBShadowTables(ctx context.Context) error
+}
+
+// TableNames holds the names of the tables written by drivers.
+// Changes is empty unless the changes of the advisories are recorded, and VulnHistory and AdvisoryHistory
+// are empty unless the history is kept.
+type TableNames struct {
+	Vulnerabilities string
+	Advisories      string
+	DataSource      string
+	Metadata        string
+	Changes         string
+	VulnHistory     string
+	AdvisoryHistory string
+	CVSS            string
+	References      string
+	CWEs            string
+	JavaArtifacts   string
+	JavaIndices     string
+}
+
+// DefaultTableNames returns the default names of all tables, including the tables that are written only on demand.
+func DefaultTableNames() TableNames {
+	return TableNames{
+		Vulnerabilities: "vulnerabilities",
+		Advisories:      "vulnerability_advisories",
+		DataSource:      "data_source",
+		Metadata:        "metadata",
+		Changes:         "vulnerability_changes",
+		VulnHistory:     "vulnerabilities_history",
+		AdvisoryHistory: "vulnerability_advisories_history",
+		CVSS:            "vulnerability_cvss",
+		References:      "vulnerability_references",
+		CWEs:            "vulnerability_cwes",
+		JavaArtifacts:   "java_artifacts",
+		JavaIndices:     "java_indices",
+	}
+}
+
+// Options holds the options of drivers.
+type Options struct {
+	// MySQLLocalInfile loads rows into MySQL with LOAD DATA LOCAL INFILE instead of INSERT.
+	MySQLLocalInfile bool
+	// SQLiteCompact writes a SQLite database meant for distribution: JSON values are compressed with zstd
+	// and advisory values are stored once per value_hash.
+	SQLiteCompact bool
+	// ParquetRowGroupSize is the maximum number of rows of a row group of Parquet files. 0 means the default of parquet-go.
+	ParquetRowGroupSize int64
+	// ParquetPartitionByPlatform writes the advisories into a Parquet file per platform.
+	ParquetPartitionByPlatform bool
+	// CSVDelimiter is the field delimiter of CSV files. Empty means ",".
+	CSVDelimiter string
+	// CSVQuoteAll quotes every field of CSV files instead of only the fields that need quoting.
+	CSVQuoteAll bool
+	// CSVNoHeader omits the header row of CSV files.
+	CSVNoHeader bool
+	// CSVGzip compresses CSV files with gzip.
+	CSVGzip bool
+	// CSVNormalized adds the columns extracted from the JSON values, such as severity and fixed_version, to CSV files.
+	CSVNormalized bool
+	// CSVColumns selects the columns written to CSV files. Tables without any selected column are not written.
+	CSVColumns []string
+	// ElasticsearchURL is the endpoint of Elasticsearch (or OpenSearch) that the bulk files are also posted to.
+	ElasticsearchURL string
+}
+
+// Metadata is the metadata of the imported Trivy DB recorded per run.
+type Metadata struct {
+	Version          int
+	UpdatedAt        time.Time
+	NextUpdate       time.Time
+	DownloadedAt     time.Time
+	TrivyDBToVersion string
+	// RowCounts holds the row counts per table name
+	RowCounts map[string]int
+}
+
+// NullTime returns a NULL value for the zero time.
+func NullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// ShadowName returns the name of the table (or index) that the next generation is loaded into.
+func ShadowName(name string) string {
+	return name + shadowSuffix
+}
+
+// OldName returns the name of the table that the previous generation is moved to while swapping.
+func OldName(name string) string {
+	return name + oldSuffix
+}
+
+// Chunk splits rows so that a multi-row INSERT of each chunk has at most maxPlaceholders placeholders.
+func Chunk(rows [][][]byte, columns, maxPlaceholders int) [][][][]byte {
+	return lo.Chunk(rows, maxPlaceholders/columns)
 }
@@ -0,0 +1,450 @@
+//go:build cgo
+
+package duckdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/marcboeker/go-duckdb"
+)
+
+type column struct {
+	name string
+	typ  string
+}
+
+var (
+	vulnerabilitiesColumns = []column{
+		{"vulnerability_id", "VARCHAR NOT NULL"},
+		{"value", "JSON NOT NULL"},
+		{"value_hash", "VARCHAR NOT NULL"},
+		{"title", "VARCHAR NOT NULL"},
+		{"severity", "VARCHAR NOT NULL"},
+		{"published_date", "TIMESTAMP"},
+		{"last_modified_date", "TIMESTAMP"},
+		{"cvss_v2_vector", "VARCHAR NOT NULL"},
+		{"cvss_v2_score", "DOUBLE"},
+		{"cvss_v3_vector", "VARCHAR NOT NULL"},
+		{"cvss_v3_score", "DOUBLE"},
+	}
+	advisoryColumns = []column{
+		{"vulnerability_id", "VARCHAR NOT NULL"},
+		{"platform", "VARCHAR NOT NULL"},
+		{"segment", "VARCHAR NOT NULL"},
+		{"package", "VARCHAR NOT NULL"},
+		{"value", "JSON NOT NULL"},
+		{"value_hash", "VARCHAR NOT NULL"},
+		{"fixed_version", "VARCHAR NOT NULL"},
+		{"affected_version", "VARCHAR NOT NULL"},
+		{"vulnerable_versions", "JSON NOT NULL"},
+		{"patched_versions", "JSON NOT NULL"},
+		{"unaffected_versions", "JSON NOT NULL"},
+		{"status", "VARCHAR NOT NULL"},
+		{"severity", "VARCHAR NOT NULL"},
+	}
+	dataSourceColumns = []column{
+		{"source_key", "VARCHAR NOT NULL"},
+		{"source_id", "VARCHAR NOT NULL"},
+		{"source_name", "VARCHAR NOT NULL"},
+		{"source_url", "VARCHAR NOT NULL"},
+		{"value_hash", "VARCHAR NOT NULL"},
+	}
+	cvssColumns = []column{
+		{"vulnerability_id", "VARCHAR NOT NULL"},
+		{"source", "VARCHAR NOT NULL"},
+		{"v2_vector", "VARCHAR NOT NULL"},
+		{"v2_score", "DOUBLE"},
+		{"v3_vector", "VARCHAR NOT NULL"},
+		{"v3_score", "DOUBLE"},
+		{"v4_vector", "VARCHAR NOT NULL"},
+		{"v4_score", "DOUBLE"},
+	}
+	referencesColumns = []column{
+		{"vulnerability_id", "VARCHAR NOT NULL"},
+		{"url", "VARCHAR NOT NULL"},
+	}
+	cwesColumns = []column{
+		{"vulnerability_id", "VARCHAR NOT NULL"},
+		{"cwe_id", "VARCHAR NOT NULL"},
+	}
+	javaArtifactsColumns = []column{
+		{"id", "BIGINT NOT NULL"},
+		{"group_id", "VARCHAR NOT NULL"},
+		{"artifact_id", "VARCHAR NOT NULL"},
+	}
+	javaIndicesColumns = []column{
+		{"artifact_id", "BIGINT NOT NULL"},
+		{"version", "VARCHAR NOT NULL"},
+		{"sha1", "VARCHAR NOT NULL"},
+		{"archive_type", "VARCHAR NOT NULL"},
+	}
+	metadataColumns = []column{
+		{"trivy_db_version", "INTEGER NOT NULL"},
+		{"updated_at", "TIMESTAMP"},
+		{"next_update", "TIMESTAMP"},
+		{"downloaded_at", "TIMESTAMP"},
+		{"trivy_db_to_version", "VARCHAR NOT NULL"},
+		{"row_counts", "JSON NOT NULL"},
+	}
+)
+
+// Duckdb writes the tables into a DuckDB database file. Tables have no surrogate id and no indexes,
+// because DuckDB scans columns instead, and rows are bulk loaded with the appender API.
+type Duckdb struct {
+	db                       *sql.DB
+	vulnerabilitiesTableName string
+	advisoryTableName        string
+	dataSourceTableName      string
+	metadataTableName        string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	shadow                   bool
+	// conn is the connection of the running load. Appenders and the transaction of the load are bound to it.
+	conn *sql.Conn
+	// now is the created_at of the rows of the running load
+	now time.Time
+}
+
+// New return *Duckdb
+func New(db *sql.DB, tableNames drivers.TableNames) (*Duckdb, error) {
+	return &Duckdb{
+		db:                       db,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+	}, nil
+}
+
+type table struct {
+	name    string
+	columns []column
+}
+
+func (m *Duckdb) tables() []table {
+	return []table{
+		{m.vulnerabilitiesTableName, vulnerabilitiesColumns},
+		{m.advisoryTableName, advisoryColumns},
+		{m.dataSourceTableName, dataSourceColumns},
+		{m.cvssTableName, cvssColumns},
+		{m.referencesTableName, referencesColumns},
+		{m.cwesTableName, cwesColumns},
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Duckdb) javaDBTables() []table {
+	return []table{
+		{m.javaArtifactsTableName, javaArtifactsColumns},
+		{m.javaIndicesTableName, javaIndicesColumns},
+	}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// createTable creates table with columns followed by created_at.
+func createTable(ctx context.Context, ex execer, table string, columns []column) error {
+	var defs []string
+	for _, c := range columns {
+		defs = append(defs, fmt.Sprintf("%s %s", c.name, c.typ))
+	}
+	defs = append(defs, "created_at TIMESTAMP DEFAULT current_timestamp")
+	stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n        %s\n    );", table, strings.Join(defs, ",\n        "))
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Duckdb) Migrate(ctx context.Context) error {
+	if err := createTable(ctx, m.db, m.metadataTableName, metadataColumns); err != nil {
+		return err
+	}
+	for _, t := range m.tables() {
+		if err := createTable(ctx, m.db, t.name, t.columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Duckdb) MigrateJavaDB(ctx context.Context) error {
+	for _, t := range m.javaDBTables() {
+		if err := createTable(ctx, m.db, t.name, t.columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Duckdb) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.tables())
+}
+
+// SwapTables drops the tables and renames the shadow tables in the transaction of the load.
+func (m *Duckdb) SwapTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.tables())
+}
+
+func (m *Duckdb) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.tables())
+}
+
+func (m *Duckdb) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Duckdb) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.javaDBTables())
+}
+
+func (m *Duckdb) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.javaDBTables())
+}
+
+// createShadowTables begins the transaction of the load on a dedicated connection and creates the shadow tables in it.
+func (m *Duckdb) createShadowTables(ctx context.Context, tables []table) error {
+	if err := m.dropShadowTables(ctx, tables); err != nil {
+		return err
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	m.now = time.Now().UTC()
+	if _, err := conn.ExecContext(ctx, "BEGIN TRANSACTION;"); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if err := createTable(ctx, conn, drivers.ShadowName(t.name), t.columns); err != nil {
+			return err
+		}
+	}
+	m.shadow = true
+	return nil
+}
+
+func (m *Duckdb) swapTables(ctx context.Context, tables []table) error {
+	conn := m.conn
+	if conn == nil {
+		var err error
+		conn, err = m.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "BEGIN TRANSACTION;"); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	m.conn = nil
+	defer conn.Close()
+	for _, t := range tables {
+		for _, stmt := range []string{
+			fmt.Sprintf("DROP TABLE IF EXISTS %s;", t.name),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", drivers.ShadowName(t.name), t.name),
+		} {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				_, _ = conn.ExecContext(ctx, "ROLLBACK;")
+				return err
+			}
+		}
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT;"); err != nil {
+		return err
+	}
+	m.shadow = false
+	return nil
+}
+
+func (m *Duckdb) dropShadowTables(ctx context.Context, tables []table) error {
+	if m.conn != nil {
+		// the shadow tables of an unfinished load only exist in its transaction
+		_, err := m.conn.ExecContext(ctx, "ROLLBACK;")
+		_ = m.conn.Close()
+		m.conn = nil
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.ShadowName(t.name))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	m.shadow = false
+	return nil
+}
+
+func (m *Duckdb) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	var rows [][]driver.Value
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []driver.Value{string(vuln[0]), string(vuln[1]), drivers.Hash(vuln[1]), v.Title, v.Severity,
+			nullTime(v.PublishedDate), nullTime(v.LastModifiedDate), v.CVSSV2Vector, nullFloat(v.CVSSV2Score), v.CVSSV3Vector, nullFloat(v.CVSSV3Score)})
+	}
+	return m.append(ctx, m.vulnerabilitiesTableName, rows)
+}
+
+func (m *Duckdb) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	var rows [][]driver.Value
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []driver.Value{string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]),
+			string(secAdvisory[4]), drivers.Hash(secAdvisory[4]), a.FixedVersion, a.AffectedVersion, drivers.JSONArray(a.VulnerableVersions),
+			drivers.JSONArray(a.PatchedVersions), drivers.JSONArray(a.UnaffectedVersions), a.Status, a.Severity})
+	}
+	return m.append(ctx, m.advisoryTableName, rows)
+}
+
+func (m *Duckdb) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	var rows [][]driver.Value
+	for _, dataSource := range dataSources {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		rows = append(rows, []driver.Value{string(dataSource[0]), item.ID, item.Name, item.URL, drivers.Hash(dataSource[1])})
+	}
+	return m.append(ctx, m.dataSourceTableName, rows)
+}
+
+func (m *Duckdb) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var rows [][]driver.Value
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []driver.Value{string(c[0]), string(c[1]), v.V2Vector, nullFloat(v.V2Score),
+			v.V3Vector, nullFloat(v.V3Score), v.V4Vector, nullFloat(v.V4Score)})
+	}
+	return m.append(ctx, m.cvssTableName, rows)
+}
+
+func (m *Duckdb) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return m.append(ctx, m.referencesTableName, stringRows(references))
+}
+
+func (m *Duckdb) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return m.append(ctx, m.cwesTableName, stringRows(cwes))
+}
+
+func (m *Duckdb) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	rows := stringRows(artifacts)
+	for _, r := range rows {
+		id, err := strconv.ParseInt(r[0].(string), 10, 64)
+		if err != nil {
+			return err
+		}
+		r[0] = id
+	}
+	return m.append(ctx, m.javaArtifactsTableName, rows)
+}
+
+func (m *Duckdb) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	rows := stringRows(indices)
+	for _, r := range rows {
+		id, err := strconv.ParseInt(r[0].(string), 10, 64)
+		if err != nil {
+			return err
+		}
+		r[0] = id
+	}
+	return m.append(ctx, m.javaIndicesTableName, rows)
+}
+
+func (m *Duckdb) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s(trivy_db_version,updated_at,next_update,downloaded_at,trivy_db_to_version,row_counts) VALUES ($1,$2,$3,$4,$5,$6);", m.metadataTableName) //nolint:gosec
+	if _, err := m.db.ExecContext(ctx, stmt, metadata.Version, nullTime(drivers.NullTime(metadata.UpdatedAt)), nullTime(drivers.NullTime(metadata.NextUpdate)),
+		nullTime(drivers.NullTime(metadata.DownloadedAt)), metadata.TrivyDBToVersion, string(counts)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// append appends rows followed by created_at to table with an appender on the connection of the load.
+func (m *Duckdb) append(ctx context.Context, table string, rows [][]driver.Value) error {
+	if m.conn == nil {
+		return fmt.Errorf("no shadow tables to append rows to %s", table)
+	}
+	return m.conn.Raw(func(c interface{}) error {
+		a, err := duckdb.NewAppenderFromConn(c.(driver.Conn), "", m.tableName(table))
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := a.AppendRow(append(r, m.now)...); err != nil {
+				_ = a.Close()
+				return err
+			}
+		}
+		return a.Close()
+	})
+}
+
+// stringRows converts rows of bytes to rows of strings, the values of VARCHAR columns.
+func stringRows(rows [][][]byte) [][]driver.Value {
+	var values [][]driver.Value
+	for _, r := range rows {
+		v := make([]driver.Value, len(r))
+		for i, b := range r {
+			v[i] = string(b)
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// nullTime returns the value of t for the appender, which takes nil for NULL.
+func nullTime(t sql.NullTime) driver.Value {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time.UTC()
+}
+
+// nullFloat returns the value of f for the appender, which takes nil for NULL.
+func nullFloat(f sql.NullFloat64) driver.Value {
+	if !f.Valid {
+		return nil
+	}
+	return f.Float64
+}
+
+func (m *Duckdb) tableName(name string) string {
+	if m.shadow {
+		return drivers.ShadowName(name)
+	}
+	return name
+}
@@ -0,0 +1,70 @@
+//go:build cgo
+
+package duckdb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestSwapTables(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("duckdb", filepath.Join(t.TempDir(), "trivy.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db, drivers.DefaultTableNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unfinished load is rolled back
+	if err := m.CreateShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00001"), []byte(`{"Title":"dropped"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DropShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte(`{"Title":"loaded","PublishedDate":"2023-01-02T03:04:05Z","CVSS":{"nvd":{"V3Vector":"CVSS:3.1/AV:N","V3Score":9.8}}}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte("debian"), []byte("12"), []byte("openssl"), []byte(`{"FixedVersion":"3.0.9-1","Severity":3}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var title, published string
+	var score float64
+	if err := db.QueryRow("SELECT string_agg(title, ','), strftime(max(published_date), '%Y-%m-%d'), max(cvss_v3_score) FROM vulnerabilities").Scan(&title, &published, &score); err != nil {
+		t.Fatal(err)
+	}
+	if title != "loaded" || published != "2023-01-02" || score != 9.8 {
+		t.Errorf("got %q %s %v", title, published, score)
+	}
+	var fixed, severity string
+	if err := db.QueryRow("SELECT value->>'$.FixedVersion', severity FROM vulnerability_advisories").Scan(&fixed, &severity); err != nil {
+		t.Fatal(err)
+	}
+	if fixed != "3.0.9-1" || severity != "HIGH" {
+		t.Errorf("got %s %s", fixed, severity)
+	}
+}
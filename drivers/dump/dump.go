@@ -0,0 +1,384 @@
+// Package dump provides a database/sql driver that writes the statements it is given into a SQL script
+// instead of executing them, so that the drivers of trivy-db-to can produce a script to be reviewed and applied later.
+package dump
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MySQL writes statements with ? placeholders as MySQL
+	MySQL = "mysql"
+	// Postgres writes statements with $n placeholders as PostgreSQL. COPY FROM STDIN is written with the rows inline as psql reads them.
+	Postgres = "postgres"
+	// SQLite writes statements with $n placeholders as SQLite
+	SQLite = "sqlite"
+)
+
+// sqlitePragmas are the values of the pragmas of a new SQLite database, which are returned when a pragma is queried.
+var sqlitePragmas = map[string]string{
+	"journal_mode": "delete",
+	"synchronous":  "2",
+	"cache_size":   "-2000",
+	"temp_store":   "0",
+}
+
+var (
+	mu sync.Mutex
+	// opened holds the scripts opened by this process. A script is truncated when it is opened first and appended to later,
+	// so that the initialization and the loads of a run are written into one script.
+	opened = map[string]bool{}
+)
+
+// Open returns *sql.DB writing the statements into the script at path in dialect.
+// Queries return a single row of zero, or the default value of a SQLite pragma, as against an empty database.
+func Open(path, dialect string) (*sql.DB, error) {
+	switch dialect {
+	case MySQL, Postgres, SQLite:
+	default:
+		return nil, fmt.Errorf("unsupported SQL dump dialect '%s'", dialect)
+	}
+	mu.Lock()
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !opened[path] {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0644) //nolint:gosec
+	if err == nil {
+		opened[path] = true
+	}
+	mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(&connector{f: f, dialect: dialect}), nil
+}
+
+// connector shares the script among the connections of *sql.DB, so statements are written in the order they are executed.
+type connector struct {
+	mu      sync.Mutex
+	f       *os.File
+	dialect string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{c: c}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return drv{}
+}
+
+// Close closes the script. It is called by (*sql.DB).Close.
+func (c *connector) Close() error {
+	return c.f.Close()
+}
+
+func (c *connector) write(s string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := io.WriteString(c.f, s)
+	return err
+}
+
+// writeStmt writes query terminated by a semicolon.
+func (c *connector) writeStmt(query string) error {
+	return c.write(strings.TrimRight(strings.TrimSpace(query), ";") + ";\n")
+}
+
+type drv struct{}
+
+func (drv) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("the SQL dump driver must be opened with dump.Open")
+}
+
+type conn struct {
+	c *connector
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	if isCopy(query) {
+		if err := c.c.writeStmt(query); err != nil {
+			return nil, err
+		}
+		return &copyStmt{c: c.c}, nil
+	}
+	return &stmt{c: c.c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if err := c.c.writeStmt("BEGIN"); err != nil {
+		return nil, err
+	}
+	return &tx{c: c.c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	q, err := interpolate(query, args, c.c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.c.writeStmt(q); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var v driver.Value = int64(0)
+	if name, ok := strings.CutPrefix(strings.TrimSpace(query), "PRAGMA "); ok && c.c.dialect == SQLite {
+		v = sqlitePragmas[strings.TrimRight(name, "; ")]
+	}
+	return &rows{values: []driver.Value{v}}, nil
+}
+
+type tx struct {
+	c *connector
+}
+
+func (t *tx) Commit() error {
+	return t.c.writeStmt("COMMIT")
+}
+
+func (t *tx) Rollback() error {
+	return t.c.writeStmt("ROLLBACK")
+}
+
+type stmt struct {
+	c     *connector
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	q, err := interpolate(s.query, namedValues(args), s.c.dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.c.writeStmt(q); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &rows{values: []driver.Value{int64(0)}}, nil
+}
+
+// copyStmt writes the rows of COPY FROM STDIN in the text format, terminated by \. when executed without arguments as lib/pq does.
+type copyStmt struct {
+	c *connector
+}
+
+func (s *copyStmt) Close() error {
+	return nil
+}
+
+func (s *copyStmt) NumInput() int {
+	return -1
+}
+
+func (s *copyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) == 0 {
+		return driver.RowsAffected(0), s.c.write("\\.\n")
+	}
+	var b strings.Builder
+	for i, v := range args {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		if err := writeCopyValue(&b, v); err != nil {
+			return nil, err
+		}
+	}
+	b.WriteByte('\n')
+	return driver.RowsAffected(0), s.c.write(b.String())
+}
+
+func (s *copyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("COPY cannot be queried")
+}
+
+type rows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *rows) Columns() []string {
+	cols := make([]string, len(r.values))
+	for i := range cols {
+		cols[i] = "column" + strconv.Itoa(i+1)
+	}
+	return cols
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+func isCopy(query string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "COPY ")
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// interpolate replaces the placeholders of query outside string literals with the literals of args.
+func interpolate(query string, args []driver.NamedValue, dialect string) (string, error) {
+	if len(args) == 0 {
+		return query, nil
+	}
+	var b strings.Builder
+	next := 0
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			end := strings.IndexByte(query[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated string literal in %q", query)
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+			continue
+		case ch == '?' && dialect == MySQL:
+			if next >= len(args) {
+				return "", fmt.Errorf("not enough arguments for %q", query)
+			}
+			if err := writeLiteral(&b, args[next].Value, dialect); err != nil {
+				return "", err
+			}
+			next++
+			continue
+		case ch == '$' && dialect != MySQL && i+1 < len(query) && isDigit(query[i+1]):
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			n, _ := strconv.Atoi(query[i+1 : j])
+			if n < 1 || n > len(args) {
+				return "", fmt.Errorf("no argument $%d for %q", n, query)
+			}
+			if err := writeLiteral(&b, args[n-1].Value, dialect); err != nil {
+				return "", err
+			}
+			i = j - 1
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+var mysqlEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
+
+func writeLiteral(b *strings.Builder, v driver.Value, dialect string) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("NULL")
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		switch {
+		case dialect == SQLite && v:
+			b.WriteString("1")
+		case dialect == SQLite:
+			b.WriteString("0")
+		default:
+			b.WriteString(strings.ToUpper(strconv.FormatBool(v)))
+		}
+	case string:
+		writeString(b, v, dialect)
+	case []byte:
+		switch dialect {
+		case MySQL:
+			writeString(b, string(v), dialect)
+		case Postgres:
+			b.WriteString(`'\x` + hex.EncodeToString(v) + `'`)
+		default:
+			b.WriteString("X'" + hex.EncodeToString(v) + "'")
+		}
+	case time.Time:
+		writeString(b, v.UTC().Format("2006-01-02 15:04:05.999999"), dialect)
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
+
+func writeString(b *strings.Builder, s, dialect string) {
+	b.WriteByte('\'')
+	if dialect == MySQL {
+		_, _ = mysqlEscaper.WriteString(b, s)
+	} else {
+		b.WriteString(strings.ReplaceAll(s, "'", "''"))
+	}
+	b.WriteByte('\'')
+}
+
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// writeCopyValue writes v in the text format of COPY.
+func writeCopyValue(b *strings.Builder, v driver.Value) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString(`\N`)
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case string:
+		_, _ = copyEscaper.WriteString(b, v)
+	case []byte:
+		b.WriteString(`\\x` + hex.EncodeToString(v))
+	case time.Time:
+		b.WriteString(v.UTC().Format("2006-01-02 15:04:05.999999"))
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	return nil
+}
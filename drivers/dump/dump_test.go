@@ -0,0 +1,82 @@
+package dump
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		query   string
+		args    []driver.Value
+		dialect string
+		want    string
+	}{
+		{
+			"INSERT INTO t(a,b,c) VALUES (?,?,?);",
+			[]driver.Value{"it's\n", nil, ts},
+			MySQL,
+			`INSERT INTO t(a,b,c) VALUES ('it\'s\n',NULL,'2023-01-02 03:04:05');`,
+		},
+		{
+			"INSERT INTO t(a,b,c) VALUES ($1,$2,'$3?'),($3,$1,$2);",
+			[]driver.Value{"it's", int64(1), 9.8},
+			Postgres,
+			"INSERT INTO t(a,b,c) VALUES ('it''s',1,'$3?'),(9.8,'it''s',1);",
+		},
+		{
+			"INSERT INTO t(a,b) VALUES ($1,$2);",
+			[]driver.Value{[]byte{0x28, 0xb5}, true},
+			SQLite,
+			"INSERT INTO t(a,b) VALUES (X'28b5',1);",
+		},
+	}
+	for _, tt := range tests {
+		got, err := interpolate(tt.query, namedValues(tt.args), tt.dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.sql")
+	db, err := Open(path, Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`COPY "t" ("a", "b") FROM STDIN`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{{"a\tb", nil}, {"c", 1.5}, {}} {
+		if _, err := stmt.Exec(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN;\nCOPY \"t\" (\"a\", \"b\") FROM STDIN;\na\\tb\t\\N\nc\t1.5\n\\.\nCOMMIT;\n"
+	if got := string(b); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,556 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const (
+	bulkExt    = ".ndjson"
+	mappingExt = ".mapping.json"
+)
+
+// disabled is the mapping of the raw values of Trivy DB, which are kept in _source without being indexed.
+var disabled = map[string]interface{}{"type": "object", "enabled": false}
+
+var vulnerabilitiesMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"dynamic": "strict",
+		"properties": map[string]interface{}{
+			"vulnerability_id":   map[string]string{"type": "keyword"},
+			"title":              map[string]string{"type": "text"},
+			"description":        map[string]string{"type": "text"},
+			"severity":           map[string]string{"type": "keyword"},
+			"published_date":     map[string]string{"type": "date"},
+			"last_modified_date": map[string]string{"type": "date"},
+			"cvss_v2_vector":     map[string]string{"type": "keyword"},
+			"cvss_v2_score":      map[string]string{"type": "double"},
+			"cvss_v3_vector":     map[string]string{"type": "keyword"},
+			"cvss_v3_score":      map[string]string{"type": "double"},
+			"references":         map[string]string{"type": "keyword"},
+			"cwe_ids":            map[string]string{"type": "keyword"},
+			"value":              disabled,
+		},
+	},
+}
+
+var advisoryMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"dynamic": "strict",
+		"properties": map[string]interface{}{
+			"vulnerability_id":    map[string]string{"type": "keyword"},
+			"platform":            map[string]string{"type": "keyword"},
+			"segment":             map[string]string{"type": "keyword"},
+			"package":             map[string]string{"type": "keyword"},
+			"fixed_version":       map[string]string{"type": "keyword"},
+			"affected_version":    map[string]string{"type": "keyword"},
+			"vulnerable_versions": map[string]string{"type": "keyword"},
+			"patched_versions":    map[string]string{"type": "keyword"},
+			"unaffected_versions": map[string]string{"type": "keyword"},
+			"status":              map[string]string{"type": "keyword"},
+			"severity":            map[string]string{"type": "keyword"},
+			"value":               disabled,
+		},
+	},
+}
+
+type vulnerabilityDoc struct {
+	VulnerabilityID  string          `json:"vulnerability_id"`
+	Title            string          `json:"title"`
+	Description      string          `json:"description"`
+	Severity         string          `json:"severity"`
+	PublishedDate    *time.Time      `json:"published_date"`
+	LastModifiedDate *time.Time      `json:"last_modified_date"`
+	CVSSV2Vector     string          `json:"cvss_v2_vector"`
+	CVSSV2Score      *float64        `json:"cvss_v2_score"`
+	CVSSV3Vector     string          `json:"cvss_v3_vector"`
+	CVSSV3Score      *float64        `json:"cvss_v3_score"`
+	References       []string        `json:"references"`
+	CWEIDs           []string        `json:"cwe_ids"`
+	Value            json.RawMessage `json:"value"`
+}
+
+type advisoryDoc struct {
+	VulnerabilityID    string          `json:"vulnerability_id"`
+	Platform           string          `json:"platform"`
+	Segment            string          `json:"segment"`
+	Package            string          `json:"package"`
+	FixedVersion       string          `json:"fixed_version"`
+	AffectedVersion    string          `json:"affected_version"`
+	VulnerableVersions []string        `json:"vulnerable_versions"`
+	PatchedVersions    []string        `json:"patched_versions"`
+	UnaffectedVersions []string        `json:"unaffected_versions"`
+	Status             string          `json:"status"`
+	Severity           string          `json:"severity"`
+	Value              json.RawMessage `json:"value"`
+}
+
+var errNotFound = errors.New("not found")
+
+type action struct {
+	Index struct {
+		Index string `json:"_index"`
+		ID    string `json:"_id"`
+	} `json:"index"`
+}
+
+// Elasticsearch writes the vulnerabilities and the advisories as files of the _bulk API of Elasticsearch (and OpenSearch),
+// <index>.ndjson with the mapping of the index in <index>.mapping.json. The index names are the table names.
+// The other tables have no index.
+//
+// If an endpoint is configured, each chunk is also posted to the _bulk API. A load is indexed into a new index,
+// <index>-<unix nanoseconds>, which the alias <index> is moved to on swap.
+type Elasticsearch struct {
+	dir                      string
+	endpoint                 *url.URL
+	client                   *http.Client
+	vulnerabilitiesTableName string
+	advisoryTableName        string
+	// files holds the open files of the running load by index name
+	files map[string]*os.File
+	// indices holds the indices of the running load by index name
+	indices map[string]string
+}
+
+// New return *Elasticsearch
+func New(dir string, tableNames drivers.TableNames, opts drivers.Options) (*Elasticsearch, error) {
+	if dir == "" {
+		return nil, errors.New("no directory to write bulk files to")
+	}
+	m := &Elasticsearch{
+		dir:                      dir,
+		client:                   &http.Client{Timeout: 5 * time.Minute},
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		files:                    map[string]*os.File{},
+		indices:                  map[string]string{},
+	}
+	if opts.ElasticsearchURL != "" {
+		u, err := url.Parse(opts.ElasticsearchURL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("invalid Elasticsearch URL '%s'", opts.ElasticsearchURL)
+		}
+		m.endpoint = u
+	}
+	return m, nil
+}
+
+type index struct {
+	name    string
+	mapping map[string]interface{}
+}
+
+func (m *Elasticsearch) indexes() []index {
+	return []index{
+		{m.vulnerabilitiesTableName, vulnerabilitiesMapping},
+		{m.advisoryTableName, advisoryMapping},
+	}
+}
+
+// Migrate writes the mappings of the indices.
+func (m *Elasticsearch) Migrate(ctx context.Context) error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil { //nolint:gosec
+		return err
+	}
+	for _, idx := range m.indexes() {
+		b, err := json.MarshalIndent(idx.mapping, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(m.dir, idx.name+mappingExt), append(b, '\n'), 0644); err != nil { //nolint:gosec
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateShadowTables opens the bulk files of the load and creates the indices of the load if an endpoint is configured.
+func (m *Elasticsearch) CreateShadowTables(ctx context.Context) error {
+	if err := m.DropShadowTables(ctx); err != nil {
+		return err
+	}
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, idx := range m.indexes() {
+		f, err := os.Create(m.shadowPath(idx.name))
+		if err != nil {
+			return err
+		}
+		m.files[idx.name] = f
+		if m.endpoint == nil {
+			continue
+		}
+		name := idx.name + "-" + suffix
+		if err := m.request(ctx, http.MethodPut, name, idx.mapping, nil); err != nil {
+			return err
+		}
+		m.indices[idx.name] = name
+	}
+	return nil
+}
+
+// SwapTables renames the bulk files and moves the aliases to the indices of the load, deleting the indices of the previous load.
+func (m *Elasticsearch) SwapTables(ctx context.Context) error {
+	for _, idx := range m.indexes() {
+		f, ok := m.files[idx.name]
+		if !ok {
+			continue
+		}
+		delete(m.files, idx.name)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Rename(m.shadowPath(idx.name), m.path(idx.name)); err != nil {
+			return err
+		}
+	}
+	if m.endpoint == nil {
+		return nil
+	}
+	var actions []interface{}
+	for _, idx := range m.indexes() {
+		name, ok := m.indices[idx.name]
+		if !ok {
+			continue
+		}
+		if err := m.checkAlias(ctx, idx.name); err != nil {
+			return err
+		}
+		if err := m.request(ctx, http.MethodPost, name+"/_refresh", nil, nil); err != nil {
+			return err
+		}
+		previous, err := m.aliasedIndices(ctx, idx.name)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, map[string]interface{}{"add": map[string]string{"index": name, "alias": idx.name}})
+		for _, p := range previous {
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]string{"index": p}})
+		}
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	// the alias is moved and the previous indices are deleted atomically
+	if err := m.request(ctx, http.MethodPost, "_aliases", map[string]interface{}{"actions": actions}, nil); err != nil {
+		return err
+	}
+	m.indices = map[string]string{}
+	return nil
+}
+
+// checkAlias returns an error if name is a concrete index, such as the one created by posting a bulk file
+// before the alias exists, because the alias cannot be added while an index has the name.
+func (m *Elasticsearch) checkAlias(ctx context.Context, name string) error {
+	res := map[string]json.RawMessage{}
+	if err := m.request(ctx, http.MethodGet, name, nil, &res); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := res[name]; ok {
+		return fmt.Errorf("'%s' is an index, not an alias: delete the index to let the loads manage the alias", name)
+	}
+	return nil
+}
+
+// aliasedIndices returns the indices that alias points to.
+func (m *Elasticsearch) aliasedIndices(ctx context.Context, alias string) ([]string, error) {
+	res := map[string]json.RawMessage{}
+	if err := m.request(ctx, http.MethodGet, "_alias/"+alias, nil, &res); err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var indices []string
+	for name := range res {
+		indices = append(indices, name)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+func (m *Elasticsearch) DropShadowTables(ctx context.Context) error {
+	var errs []error
+	for _, idx := range m.indexes() {
+		if f, ok := m.files[idx.name]; ok {
+			delete(m.files, idx.name)
+			errs = append(errs, f.Close())
+		}
+		if err := os.Remove(m.shadowPath(idx.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		if name, ok := m.indices[idx.name]; ok {
+			delete(m.indices, idx.name)
+			errs = append(errs, m.request(ctx, http.MethodDelete, name, nil, nil))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Elasticsearch) path(name string) string {
+	return filepath.Join(m.dir, name+bulkExt)
+}
+
+func (m *Elasticsearch) shadowPath(name string) string {
+	return filepath.Join(m.dir, drivers.ShadowName(name)+bulkExt)
+}
+
+// bulk writes the documents as a request body of the _bulk API into the file of index, and posts it if an endpoint is configured.
+// The actions of the file name the alias, while the documents are posted to the index of the load.
+func (m *Elasticsearch) bulk(ctx context.Context, name string, ids []string, docs []interface{}) error {
+	f, ok := m.files[name]
+	if !ok {
+		return fmt.Errorf("no shadow index to write documents of %s to", name)
+	}
+	var sources [][]byte
+	for _, doc := range docs {
+		b, err := marshal(doc)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, b)
+	}
+	body, err := bulkBody(name, ids, sources)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		return err
+	}
+	if m.endpoint == nil || len(docs) == 0 {
+		return nil
+	}
+	if body, err = bulkBody(m.indices[name], ids, sources); err != nil {
+		return err
+	}
+	var res struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := m.request(ctx, http.MethodPost, "_bulk", body, &res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	for _, item := range res.Items {
+		for _, r := range item {
+			if len(r.Error) > 0 {
+				return fmt.Errorf("failed to index a document into %s: %s", name, r.Error)
+			}
+		}
+	}
+	return fmt.Errorf("failed to index documents into %s", name)
+}
+
+// bulkBody returns the lines of the index actions into index followed by the documents.
+func bulkBody(index string, ids []string, sources [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, src := range sources {
+		var a action
+		a.Index.Index = index
+		a.Index.ID = ids[i]
+		b, err := marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+		buf.Write(src)
+	}
+	return buf.Bytes(), nil
+}
+
+// marshal returns v as a line of JSON. Version constraints such as "< 1.0.0" are kept unescaped.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Elasticsearch) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	var (
+		ids  []string
+		docs []interface{}
+	)
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		var extra struct {
+			Description string   `json:"Description"`
+			References  []string `json:"References"`
+			CweIDs      []string `json:"CweIDs"`
+		}
+		if err := json.Unmarshal(vuln[1], &extra); err != nil {
+			return err
+		}
+		ids = append(ids, string(vuln[0]))
+		docs = append(docs, vulnerabilityDoc{
+			VulnerabilityID:  string(vuln[0]),
+			Title:            v.Title,
+			Description:      extra.Description,
+			Severity:         v.Severity,
+			PublishedDate:    nullTime(v.PublishedDate),
+			LastModifiedDate: nullTime(v.LastModifiedDate),
+			CVSSV2Vector:     v.CVSSV2Vector,
+			CVSSV2Score:      nullFloat(v.CVSSV2Score),
+			CVSSV3Vector:     v.CVSSV3Vector,
+			CVSSV3Score:      nullFloat(v.CVSSV3Score),
+			References:       nonNil(extra.References),
+			CWEIDs:           nonNil(extra.CweIDs),
+			Value:            vuln[1],
+		})
+	}
+	return m.bulk(ctx, m.vulnerabilitiesTableName, ids, docs)
+}
+
+func (m *Elasticsearch) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	var (
+		ids  []string
+		docs []interface{}
+	)
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		// the key of an advisory may be longer than _id allows
+		ids = append(ids, drivers.Hash([]byte(drivers.Key(string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3])))))
+		docs = append(docs, advisoryDoc{
+			VulnerabilityID:    string(secAdvisory[0]),
+			Platform:           string(secAdvisory[1]),
+			Segment:            string(secAdvisory[2]),
+			Package:            string(secAdvisory[3]),
+			FixedVersion:       a.FixedVersion,
+			AffectedVersion:    a.AffectedVersion,
+			VulnerableVersions: a.VulnerableVersions,
+			PatchedVersions:    a.PatchedVersions,
+			UnaffectedVersions: a.UnaffectedVersions,
+			Status:             a.Status,
+			Severity:           a.Severity,
+			Value:              secAdvisory[4],
+		})
+	}
+	return m.bulk(ctx, m.advisoryTableName, ids, docs)
+}
+
+func (m *Elasticsearch) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	return nil
+}
+
+func (m *Elasticsearch) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	return nil
+}
+
+func (m *Elasticsearch) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return nil
+}
+
+func (m *Elasticsearch) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return nil
+}
+
+func (m *Elasticsearch) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	return nil
+}
+
+// request sends body as JSON (or NDJSON if []byte) to path of the endpoint and decodes the response into res if not nil.
+func (m *Elasticsearch) request(ctx context.Context, method, path string, body interface{}, res interface{}) error {
+	var (
+		r           io.Reader
+		contentType = "application/json"
+	)
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+		contentType = "application/x-ndjson"
+	default:
+		j, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(j)
+	}
+	u := m.endpoint.JoinPath(path)
+	u.User = nil
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if m.endpoint.User != nil {
+		password, _ := m.endpoint.User.Password()
+		req.SetBasicAuth(m.endpoint.User.Username(), password)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, u.Redacted(), errNotFound)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s %s: %s: %s", method, u.Redacted(), resp.Status, strings.TrimSpace(string(b)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(b, res)
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	u := t.Time.UTC()
+	return &u
+}
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+// server is a stand-in of Elasticsearch keeping the documents and the aliases.
+type server struct {
+	mu      sync.Mutex
+	docs    map[string]map[string]json.RawMessage
+	aliases map[string]string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPut:
+		s.docs[path] = map[string]json.RawMessage{}
+	case r.Method == http.MethodPost && path == "_bulk":
+		sc := bufio.NewScanner(r.Body)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var a action
+			if err := json.Unmarshal(sc.Bytes(), &a); err != nil || !sc.Scan() {
+				http.Error(w, "invalid bulk body", http.StatusBadRequest)
+				return
+			}
+			s.docs[a.Index.Index][a.Index.ID] = append(json.RawMessage{}, sc.Bytes()...)
+		}
+		_, _ = io.WriteString(w, `{"errors":false,"items":[]}`)
+		return
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "_alias/"):
+		index, ok := s.aliases[strings.TrimPrefix(path, "_alias/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"`+index+`":{"aliases":{}}}`)
+		return
+	case r.Method == http.MethodGet:
+		index, ok := s.aliases[path]
+		if !ok {
+			if _, ok := s.docs[path]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			index = path
+		}
+		_, _ = io.WriteString(w, `{"`+index+`":{"aliases":{}}}`)
+		return
+	case r.Method == http.MethodPost && path == "_aliases":
+		var req struct {
+			Actions []map[string]map[string]string `json:"actions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, a := range req.Actions {
+			if add, ok := a["add"]; ok {
+				s.aliases[add["alias"]] = add["index"]
+			}
+			if rm, ok := a["remove_index"]; ok {
+				delete(s.docs, rm["index"])
+			}
+		}
+	}
+	_, _ = io.WriteString(w, `{}`)
+}
+
+func TestSwapTables(t *testing.T) {
+	ctx := context.Background()
+	s := &server{docs: map[string]map[string]json.RawMessage{}, aliases: map[string]string{}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	m, err := New(t.TempDir(), drivers.DefaultTableNames(), drivers.Options{ElasticsearchURL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte(`{"Title":"loaded","Description":"a bad thing","PublishedDate":"2023-01-02T03:04:05Z"}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the previous indices are deleted
+	if len(s.docs) != 2 {
+		t.Errorf("got %d indices, want 2", len(s.docs))
+	}
+	var doc vulnerabilityDoc
+	if err := json.Unmarshal(s.docs[s.aliases["vulnerabilities"]]["CVE-2023-00002"], &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "loaded" || doc.Description != "a bad thing" || doc.PublishedDate.Format("2006-01-02") != "2023-01-02" {
+		t.Errorf("got %+v", doc)
+	}
+}
+
+func TestSwapTablesIntoIndex(t *testing.T) {
+	ctx := context.Background()
+	// vulnerabilities is a concrete index, as created by posting a bulk file before the alias exists
+	s := &server{docs: map[string]map[string]json.RawMessage{"vulnerabilities": {}}, aliases: map[string]string{}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	m, err := New(t.TempDir(), drivers.DefaultTableNames(), drivers.Options{ElasticsearchURL: ts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SwapTables(ctx); err == nil {
+		t.Error("want an error swapping an alias over an index")
+	}
+	if len(s.aliases) != 0 {
+		t.Errorf("got aliases %v, want none", s.aliases)
+	}
+}
@@ -0,0 +1,544 @@
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const ext = ".jsonl"
+
+type vulnerabilityLine struct {
+	Table           string          `json:"table,omitempty"`
+	VulnerabilityID string          `json:"vulnerability_id"`
+	Vulnerability   json.RawMessage `json:"vulnerability"`
+}
+
+type advisoryLine struct {
+	Table           string          `json:"table,omitempty"`
+	VulnerabilityID string          `json:"vulnerability_id"`
+	Platform        string          `json:"platform"`
+	Segment         string          `json:"segment"`
+	Package         string          `json:"package"`
+	Advisory        json.RawMessage `json:"advisory"`
+}
+
+type dataSourceLine struct {
+	Table      string          `json:"table,omitempty"`
+	SourceKey  string          `json:"source_key"`
+	DataSource json.RawMessage `json:"data_source"`
+}
+
+type cvssLine struct {
+	Table           string          `json:"table,omitempty"`
+	VulnerabilityID string          `json:"vulnerability_id"`
+	Source          string          `json:"source"`
+	CVSS            json.RawMessage `json:"cvss"`
+}
+
+type referenceLine struct {
+	Table           string `json:"table,omitempty"`
+	VulnerabilityID string `json:"vulnerability_id"`
+	URL             string `json:"url"`
+}
+
+type cweLine struct {
+	Table           string `json:"table,omitempty"`
+	VulnerabilityID string `json:"vulnerability_id"`
+	CWEID           string `json:"cwe_id"`
+}
+
+type javaArtifactLine struct {
+	Table      string `json:"table,omitempty"`
+	ID         int64  `json:"id"`
+	GroupID    string `json:"group_id"`
+	ArtifactID string `json:"artifact_id"`
+}
+
+type javaIndexLine struct {
+	Table       string `json:"table,omitempty"`
+	ArtifactID  int64  `json:"artifact_id"`
+	Version     string `json:"version"`
+	SHA1        string `json:"sha1"`
+	ArchiveType string `json:"archive_type"`
+}
+
+type metadataLine struct {
+	Table            string         `json:"table,omitempty"`
+	TrivyDBVersion   int            `json:"trivy_db_version"`
+	UpdatedAt        *time.Time     `json:"updated_at"`
+	NextUpdate       *time.Time     `json:"next_update"`
+	DownloadedAt     *time.Time     `json:"downloaded_at"`
+	TrivyDBToVersion string         `json:"trivy_db_to_version"`
+	RowCounts        map[string]int `json:"row_counts"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
+
+// Jsonl writes the tables as newline-delimited JSON. Values of Trivy DB are embedded as JSON objects.
+//
+// In the directory mode each table is written into <table>.jsonl, which is written under a shadow name and renamed on swap.
+// In the stream mode all tables are written into a single file or stdout, and each line has the name of its table in "table".
+type Jsonl struct {
+	dir                      string
+	path                     string
+	stream                   bool
+	vulnerabilitiesTableName string
+	advisoryTableName        string
+	dataSourceTableName      string
+	metadataTableName        string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	// outputs holds the outputs of the running load by table name. All tables share one output in the stream mode.
+	outputs map[string]*output
+}
+
+// New return *Jsonl writing a file per table into dir
+func New(dir string, tableNames drivers.TableNames) (*Jsonl, error) {
+	if dir == "" {
+		return nil, errors.New("no directory to write JSON Lines files to")
+	}
+	m := newJsonl(tableNames)
+	m.dir = dir
+	return m, nil
+}
+
+// NewStream return *Jsonl writing all tables into the file at path. "-" (or /dev/stdout) means stdout.
+func NewStream(path string, tableNames drivers.TableNames) (*Jsonl, error) {
+	if path == "" {
+		return nil, errors.New("no file to write JSON Lines to")
+	}
+	m := newJsonl(tableNames)
+	m.path = path
+	m.stream = true
+	return m, nil
+}
+
+func newJsonl(tableNames drivers.TableNames) *Jsonl {
+	return &Jsonl{
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+		outputs:                  map[string]*output{},
+	}
+}
+
+func (m *Jsonl) tables() []string {
+	return []string{
+		m.vulnerabilitiesTableName,
+		m.advisoryTableName,
+		m.dataSourceTableName,
+		m.cvssTableName,
+		m.referencesTableName,
+		m.cwesTableName,
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Jsonl) javaDBTables() []string {
+	return []string{
+		m.javaArtifactsTableName,
+		m.javaIndicesTableName,
+	}
+}
+
+func (m *Jsonl) stdout() bool {
+	return m.stream && (m.path == "-" || m.path == os.Stdout.Name())
+}
+
+func (m *Jsonl) Migrate(ctx context.Context) error {
+	return m.mkdir()
+}
+
+func (m *Jsonl) MigrateJavaDB(ctx context.Context) error {
+	return m.mkdir()
+}
+
+func (m *Jsonl) mkdir() error {
+	switch {
+	case m.stdout():
+		return nil
+	case m.stream:
+		return os.MkdirAll(filepath.Dir(m.path), 0755) //nolint:gosec
+	default:
+		return os.MkdirAll(m.dir, 0755) //nolint:gosec
+	}
+}
+
+func (m *Jsonl) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.tables(), false)
+}
+
+// SwapTables closes the files of the load and renames them to the names of the tables.
+func (m *Jsonl) SwapTables(ctx context.Context) error {
+	return m.swapTables(m.tables())
+}
+
+func (m *Jsonl) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.tables())
+}
+
+// CreateJavaDBShadowTables opens the files of the tables of Trivy Java DB.
+// In the stream mode the rows are appended to the file written by the load of Trivy DB.
+func (m *Jsonl) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.javaDBTables(), m.stream)
+}
+
+func (m *Jsonl) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(m.javaDBTables())
+}
+
+func (m *Jsonl) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.javaDBTables())
+}
+
+func (m *Jsonl) createShadowTables(tables []string, appending bool) error {
+	if err := m.dropShadowTables(tables); err != nil {
+		return err
+	}
+	if m.stream {
+		out, err := m.openStream(appending)
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			m.outputs[t] = out
+		}
+		return nil
+	}
+	for _, t := range tables {
+		out, err := openFile(m.shadowPath(t), false)
+		if err != nil {
+			return err
+		}
+		m.outputs[t] = out
+	}
+	return nil
+}
+
+// openStream opens the output of the stream mode. Unless appending, a new file is written under a shadow name.
+func (m *Jsonl) openStream(appending bool) (*output, error) {
+	switch {
+	case m.stdout():
+		return newOutput(os.Stdout, nil, ""), nil
+	case appending:
+		return openFile(m.path, true)
+	default:
+		out, err := openFile(drivers.ShadowName(m.path), false)
+		if err != nil {
+			return nil, err
+		}
+		out.rename = m.path
+		return out, nil
+	}
+}
+
+func (m *Jsonl) swapTables(tables []string) error {
+	var outs []*output
+	for _, t := range tables {
+		if out, ok := m.outputs[t]; ok && !slices.Contains(outs, out) {
+			outs = append(outs, out)
+		}
+		if err := m.closeOutput(t); err != nil {
+			return err
+		}
+	}
+	if m.stream {
+		for _, out := range outs {
+			if err := out.commit(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, t := range tables {
+		if err := os.Rename(m.shadowPath(t), m.filePath(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) dropShadowTables(tables []string) error {
+	var errs []error
+	for _, t := range tables {
+		out, ok := m.outputs[t]
+		errs = append(errs, m.closeOutput(t))
+		switch {
+		case !m.stream:
+			errs = append(errs, removeIfExists(m.shadowPath(t)))
+		case ok && out.rename != "":
+			errs = append(errs, removeIfExists(out.f.Name()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeOutput closes the output of table unless other tables still write to it.
+func (m *Jsonl) closeOutput(table string) error {
+	out, ok := m.outputs[table]
+	if !ok {
+		return nil
+	}
+	delete(m.outputs, table)
+	for _, o := range m.outputs {
+		if o == out {
+			return nil
+		}
+	}
+	return out.close()
+}
+
+func (m *Jsonl) filePath(table string) string {
+	return filepath.Join(m.dir, table+ext)
+}
+
+func (m *Jsonl) shadowPath(table string) string {
+	return filepath.Join(m.dir, drivers.ShadowName(table)+ext)
+}
+
+// tableField returns the value of the "table" field of the lines of table, which is set in the stream mode only.
+func (m *Jsonl) tableField(table string) string {
+	if !m.stream {
+		return ""
+	}
+	return table
+}
+
+func (m *Jsonl) write(table string, v interface{}) error {
+	out, ok := m.outputs[table]
+	if !ok {
+		return errors.New("no shadow table to write rows of " + table + " to")
+	}
+	return out.enc.Encode(v)
+}
+
+func (m *Jsonl) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	for _, vuln := range vulns {
+		if err := m.write(m.vulnerabilitiesTableName, vulnerabilityLine{
+			Table:           m.tableField(m.vulnerabilitiesTableName),
+			VulnerabilityID: string(vuln[0]),
+			Vulnerability:   vuln[1],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	for _, secAdvisory := range secAdvisories {
+		if err := m.write(m.advisoryTableName, advisoryLine{
+			Table:           m.tableField(m.advisoryTableName),
+			VulnerabilityID: string(secAdvisory[0]),
+			Platform:        string(secAdvisory[1]),
+			Segment:         string(secAdvisory[2]),
+			Package:         string(secAdvisory[3]),
+			Advisory:        secAdvisory[4],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	for _, dataSource := range dataSources {
+		if err := m.write(m.dataSourceTableName, dataSourceLine{
+			Table:      m.tableField(m.dataSourceTableName),
+			SourceKey:  string(dataSource[0]),
+			DataSource: dataSource[1],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	for _, c := range cvss {
+		if err := m.write(m.cvssTableName, cvssLine{
+			Table:           m.tableField(m.cvssTableName),
+			VulnerabilityID: string(c[0]),
+			Source:          string(c[1]),
+			CVSS:            c[2],
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	for _, r := range references {
+		if err := m.write(m.referencesTableName, referenceLine{
+			Table:           m.tableField(m.referencesTableName),
+			VulnerabilityID: string(r[0]),
+			URL:             string(r[1]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	for _, r := range cwes {
+		if err := m.write(m.cwesTableName, cweLine{
+			Table:           m.tableField(m.cwesTableName),
+			VulnerabilityID: string(r[0]),
+			CWEID:           string(r[1]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	for _, r := range artifacts {
+		id, err := strconv.ParseInt(string(r[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := m.write(m.javaArtifactsTableName, javaArtifactLine{
+			Table:      m.tableField(m.javaArtifactsTableName),
+			ID:         id,
+			GroupID:    string(r[1]),
+			ArtifactID: string(r[2]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Jsonl) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	for _, r := range indices {
+		id, err := strconv.ParseInt(string(r[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := m.write(m.javaIndicesTableName, javaIndexLine{
+			Table:       m.tableField(m.javaIndicesTableName),
+			ArtifactID:  id,
+			Version:     string(r[1]),
+			SHA1:        string(r[2]),
+			ArchiveType: string(r[3]),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertMetadata appends the metadata of the run to the metadata file, or to the stream in the stream mode.
+func (m *Jsonl) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	var (
+		out *output
+		err error
+	)
+	switch {
+	case m.stream:
+		out, err = m.openStream(true)
+	default:
+		out, err = openFile(m.filePath(m.metadataTableName), true)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.enc.Encode(metadataLine{
+		Table:            m.tableField(m.metadataTableName),
+		TrivyDBVersion:   metadata.Version,
+		UpdatedAt:        nullTime(metadata.UpdatedAt),
+		NextUpdate:       nullTime(metadata.NextUpdate),
+		DownloadedAt:     nullTime(metadata.DownloadedAt),
+		TrivyDBToVersion: metadata.TrivyDBToVersion,
+		RowCounts:        metadata.RowCounts,
+		CreatedAt:        time.Now().UTC(),
+	}); err != nil {
+		_ = out.close()
+		return err
+	}
+	return out.close()
+}
+
+// output is a buffered writer of lines.
+type output struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+	// f is nil for stdout
+	f *os.File
+	// rename is the path f is renamed to on commit
+	rename string
+}
+
+func newOutput(w io.Writer, f *os.File, rename string) *output {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &output{w: bw, enc: enc, f: f, rename: rename}
+}
+
+func openFile(path string, appending bool) (*output, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0644) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+	return newOutput(f, f, ""), nil
+}
+
+func (o *output) close() error {
+	err := o.w.Flush()
+	if o.f == nil {
+		return err
+	}
+	if cerr := o.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// commit renames the closed file to its final path.
+func (o *output) commit() error {
+	if o.rename == "" {
+		return nil
+	}
+	return os.Rename(o.f.Name(), o.rename)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,72 @@
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "trivy.jsonl")
+	m, err := NewStream(path, drivers.DefaultTableNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte("debian"), []byte("12"), []byte("openssl"), []byte(`{"FixedVersion":"3.0.9-1"}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the rows of Trivy Java DB are appended
+	if err := m.CreateJavaDBShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertJavaArtifacts(ctx, [][][]byte{{[]byte("1"), []byte("com.google.guava"), []byte("guava")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SwapJavaDBTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []map[string]interface{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var l map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if got := lines[0]["advisory"].(map[string]interface{})["FixedVersion"]; lines[0]["table"] != "vulnerability_advisories" || lines[0]["platform"] != "debian" || got != "3.0.9-1" {
+		t.Errorf("got %v", lines[0])
+	}
+	if lines[1]["table"] != "java_artifacts" || lines[1]["id"] != float64(1) {
+		t.Errorf("got %v", lines[1])
+	}
+	if _, err := os.Stat(drivers.ShadowName(path)); !os.IsNotExist(err) {
+		t.Errorf("shadow file remains: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+var (
+	vulnHistoryInsertColumns     = append(append([]string{}, vulnerabilityInsertColumns...), "valid_from")
+	advisoryHistoryInsertColumns = append(append([]string{}, advisoryInsertColumns...), "valid_from")
+)
+
+// createHistoryTables creates the history tables whose names are given.
+func (m *Mysql) createHistoryTables(ctx context.Context) error {
+	if m.vulnHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigint PRIMARY KEY AUTO_INCREMENT,
+vulnerability_id varchar (128) NOT NULL,
+value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+title text,
+severity varchar (16) NOT NULL DEFAULT '',
+published_date datetime,
+last_modified_date datetime,
+cvss_v2_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v2_score double,
+cvss_v3_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v3_score double,
+valid_from datetime NOT NULL,
+valid_to datetime,
+INDEX vh_vulnerability_id_idx (vulnerability_id, valid_to) USING BTREE,
+INDEX vh_valid_idx (valid_from, valid_to) USING BTREE
+) COMMENT = 'history of vulnerabilities obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.vulnHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if m.advisoryHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigint PRIMARY KEY AUTO_INCREMENT,
+vulnerability_id varchar (128) NOT NULL,
+platform varchar (50) NOT NULL,
+segment varchar (50) NOT NULL,
+package varchar (100) NOT NULL,
+value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+fixed_version varchar (255) NOT NULL DEFAULT '',
+affected_version varchar (255) NOT NULL DEFAULT '',
+vulnerable_versions json,
+patched_versions json,
+unaffected_versions json,
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
+valid_from datetime NOT NULL,
+valid_to datetime,
+INDEX vah_vulnerability_advisories_idx (vulnerability_id, platform, segment, package, valid_to) USING BTREE,
+INDEX vah_source_package_idx (platform, segment, package) USING BTREE,
+INDEX vah_valid_idx (valid_from, valid_to) USING BTREE
+) COMMENT = 'history of vulnerability advisories obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.advisoryHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mysql) VulnHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s WHERE valid_to IS NULL;", m.vulnHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Mysql) VulnAdvisoryHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s WHERE valid_to IS NULL;", m.advisoryHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Mysql) InsertVulnHistory(ctx context.Context, validFrom time.Time, vulns [][][]byte) error {
+	rows, err := vulnerabilityRows(vulns)
+	if err != nil {
+		return err
+	}
+	return m.insert(ctx, m.vulnHistoryTableName, vulnHistoryInsertColumns, validFromRows(rows, validFrom))
+}
+
+func (m *Mysql) InsertVulnAdvisoryHistory(ctx context.Context, validFrom time.Time, secAdvisories [][][]byte) error {
+	rows, err := advisoryRows(secAdvisories)
+	if err != nil {
+		return err
+	}
+	return m.insert(ctx, m.advisoryHistoryTableName, advisoryHistoryInsertColumns, validFromRows(rows, validFrom))
+}
+
+// validFromRows appends validFrom to the rows.
+func validFromRows(rows [][]interface{}, validFrom time.Time) [][]interface{} {
+	for i := range rows {
+		rows[i] = append(rows[i], validFrom.UTC())
+	}
+	return rows
+}
+
+func (m *Mysql) CloseVulnHistory(ctx context.Context, validTo time.Time, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = ? WHERE vulnerability_id = ? AND valid_to IS NULL;", m.vulnHistoryTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{validTo.UTC(), vuln[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Mysql) CloseVulnAdvisoryHistory(ctx context.Context, validTo time.Time, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = ? WHERE vulnerability_id = ? AND platform = ? AND segment = ? AND package = ? AND valid_to IS NULL;", m.advisoryHistoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{validTo.UTC(), secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
@@ -0,0 +1,159 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// erNotAllowedCommand is returned by servers with local_infile disabled
+	erNotAllowedCommand = 1148
+	// erClientLocalFilesDisabled is returned by MySQL 8.0 servers with local_infile disabled
+	erClientLocalFilesDisabled = 3948
+)
+
+var readerSeq atomic.Uint64
+
+// loadData streams rows into table with LOAD DATA LOCAL INFILE through a reader handler of go-sql-driver/mysql.
+// Rows are written in the default format of LOAD DATA: tab separated fields escaped by backslash.
+// LOAD DATA LOCAL skips or truncates the rows that do not fit the table with warnings, so warnings fail the load.
+func (m *Mysql) loadData(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	// warnings are kept per session, so they are read on the connection of the statement
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	name := fmt.Sprintf("trivy-db-to-%d", readerSeq.Add(1))
+	pr, pw := io.Pipe()
+	mysql.RegisterReaderHandler(name, func() io.Reader { return pr })
+	defer mysql.DeregisterReaderHandler(name)
+
+	done := make(chan error, 1)
+	go func() {
+		err := writeInfile(pw, rows)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+	stmt := fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8mb4 (%s);", name, m.tableName(table), strings.Join(columns, ","))
+	_, err = conn.ExecContext(ctx, stmt)
+	// unblock the writer if the server did not read all rows
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if werr := <-done; err == nil && werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		return werr
+	}
+	if err != nil {
+		return err
+	}
+	return checkWarnings(ctx, conn)
+}
+
+// checkWarnings returns an error holding the first warning if the last statement on conn raised warnings.
+func checkWarnings(ctx context.Context, conn *sql.Conn) error {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var (
+		count   int
+		level   string
+		code    int
+		message string
+	)
+	for rows.Next() {
+		if count == 0 {
+			if err := rows.Scan(&level, &code, &message); err != nil {
+				return err
+			}
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("LOAD DATA LOCAL INFILE raised %d warnings: %s %d: %s", count, level, code, message)
+	}
+	return nil
+}
+
+// localInfileDisabled reports whether err is returned because the server disallows LOAD DATA LOCAL INFILE.
+func localInfileDisabled(err error) bool {
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) {
+		return false
+	}
+	switch merr.Number {
+	case erNotAllowedCommand, erClientLocalFilesDisabled:
+		return true
+	}
+	// MySQL compatible servers such as go-mysql-server report it as an unknown error
+	return strings.Contains(merr.Message, "local_infile")
+}
+
+func writeInfile(w io.Writer, rows [][]interface{}) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range rows {
+		for i, v := range r {
+			if i > 0 {
+				if err := bw.WriteByte('\t'); err != nil {
+					return err
+				}
+			}
+			if err := writeInfileValue(bw, v); err != nil {
+				return err
+			}
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeInfileValue(w *bufio.Writer, v interface{}) error {
+	if valuer, ok := v.(driver.Valuer); ok {
+		var err error
+		v, err = valuer.Value()
+		if err != nil {
+			return err
+		}
+	}
+	var s string
+	switch v := v.(type) {
+	case nil:
+		_, err := w.WriteString(`\N`)
+		return err
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case int:
+		s = strconv.Itoa(v)
+	case time.Time:
+		s = v.UTC().Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Errorf("unsupported value type %T", v)
+	}
+	_, err := infileEscaper.WriteString(w, s)
+	return err
+}
+
+var infileEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`, "\x00", `\0`)
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestWriteInfile(t *testing.T) {
+	rows := [][]interface{}{
+		{"CVE-2023-0001", []byte("tab\tnew\nline \\ back"), nil, 9.8},
+		{"CVE-2023-0002", sql.NullString{}, sql.NullString{String: "x", Valid: true}, int64(3)},
+	}
+	buf := new(bytes.Buffer)
+	if err := writeInfile(buf, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "CVE-2023-0001\ttab\\tnew\\nline \\\\ back\t\\N\t9.8\n" +
+		"CVE-2023-0002\t\\N\tx\t3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLocalInfileFallback(t *testing.T) {
+	s := &fakeServer{loadErr: &mysql.MySQLError{Number: erNotAllowedCommand, Message: "The used command is not allowed with this MySQL version"}}
+	m, err := New(sql.OpenDB(s), drivers.DefaultTableNames(), drivers.Options{MySQLLocalInfile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwes := [][][]byte{{[]byte("CVE-2023-0001"), []byte("CWE-79")}}
+	for i := 0; i < 2; i++ {
+		if err := m.InsertVulnCWEs(context.Background(), cwes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"LOAD DATA", "INSERT INTO vulnerability_cwes", "INSERT INTO vulnerability_cwes"}
+	if len(s.stmts) != len(want) {
+		t.Fatalf("got %q, want statements starting with %q", s.stmts, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(s.stmts[i], want[i]) {
+			t.Errorf("got %q, want a statement starting with %q", s.stmts[i], want[i])
+		}
+	}
+}
+
+func TestLocalInfileWarnings(t *testing.T) {
+	s := &fakeServer{warnings: [][]driver.Value{{"Warning", int64(1265), "Data truncated for column 'cwe_id' at row 1"}}}
+	m, err := New(sql.OpenDB(s), drivers.DefaultTableNames(), drivers.Options{MySQLLocalInfile: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.InsertVulnCWEs(context.Background(), [][][]byte{{[]byte("CVE-2023-0001"), []byte("CWE-79")}})
+	if err == nil || !strings.Contains(err.Error(), "Data truncated") {
+		t.Errorf("got error %v, want the warning of LOAD DATA", err)
+	}
+}
+
+// fakeServer is a connector to a fake MySQL server that records the executed statements.
+// LOAD DATA fails with loadErr, and SHOW WARNINGS returns warnings.
+type fakeServer struct {
+	loadErr  error
+	warnings [][]driver.Value
+	stmts    []string
+}
+
+func (s *fakeServer) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{s: s}, nil
+}
+
+func (s *fakeServer) Driver() driver.Driver {
+	return s
+}
+
+func (s *fakeServer) Open(name string) (driver.Conn, error) {
+	return &fakeConn{s: s}, nil
+}
+
+type fakeConn struct {
+	s *fakeServer
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.s.stmts = append(c.s.stmts, query)
+	if strings.HasPrefix(query, "LOAD DATA") && c.s.loadErr != nil {
+		return nil, c.s.loadErr
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{values: c.s.warnings}, nil
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"Level", "Code", "Message"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
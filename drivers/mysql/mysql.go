@@ -7,6 +7,26 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/samber/lo"
+)
+
+const (
+	// maxPlaceholders is the maximum number of placeholders in a prepared statement
+	maxPlaceholders = 65535
+
+	vulnerabilityColumns = "title,severity,published_date,last_modified_date,cvss_v2_vector,cvss_v2_score,cvss_v3_vector,cvss_v3_score"
+	advisoryColumns      = "fixed_version,affected_version,vulnerable_versions,patched_versions,unaffected_versions,status,severity"
+	cvssColumns          = "v2_vector,v2_score,v3_vector,v3_score,v4_vector,v4_score"
+)
+
+var (
+	vulnerabilityInsertColumns = append([]string{"vulnerability_id", "value", "value_hash"}, strings.Split(vulnerabilityColumns, ",")...)
+	advisoryInsertColumns      = append([]string{"vulnerability_id", "platform", "segment", "package", "value", "value_hash"}, strings.Split(advisoryColumns, ",")...)
+	cvssInsertColumns          = append([]string{"vulnerability_id", "source"}, strings.Split(cvssColumns, ",")...)
+	changeInsertColumns        = []string{"run_id", "recorded_at", "change_type", "vulnerability_id", "platform", "segment", "package", "fixed_version", "status", "severity"}
 )
 
 type Mysql struct {
@@ -14,19 +34,52 @@ type Mysql struct {
 	vulnerabilitiesTableName string
 	advisoryTableName        string
 	dataSourceTableName      string
+	metadataTableName        string
+	changesTableName         string
+	vulnHistoryTableName     string
+	advisoryHistoryTableName string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	shadow                   bool
+	localInfile              bool
 }
 
 // New return *Mysql
-func New(db *sql.DB, vulnerabilitiesTableName, advisoryTableName string, dataSourceTableName string) (*Mysql, error) {
+func New(db *sql.DB, tableNames drivers.TableNames, opts drivers.Options) (*Mysql, error) {
 	return &Mysql{
 		db:                       db,
-		vulnerabilitiesTableName: vulnerabilitiesTableName,
-		advisoryTableName:        advisoryTableName,
-		dataSourceTableName:      dataSourceTableName,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		changesTableName:         tableNames.Changes,
+		vulnHistoryTableName:     tableNames.VulnHistory,
+		advisoryHistoryTableName: tableNames.AdvisoryHistory,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+		localInfile:              opts.MySQLLocalInfile,
 	}, nil
 }
 
 func (m *Mysql) Migrate(ctx context.Context) error {
+	if err := m.createMetadataTable(ctx); err != nil {
+		return err
+	}
+	if m.changesTableName != "" {
+		if err := m.createChangesTable(ctx); err != nil {
+			return err
+		}
+	}
+	if err := m.createHistoryTables(ctx); err != nil {
+		return err
+	}
+
 	var count int
 	stmt := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = database() AND table_name IN ('%s', '%s','%s');", m.vulnerabilitiesTableName, m.advisoryTableName, m.dataSourceTableName) //nolint:gosec
 	if err := m.db.QueryRowContext(ctx, stmt).Scan(&count); err != nil {
@@ -47,7 +100,7 @@ func (m *Mysql) Migrate(ctx context.Context) error {
 		if _, err := m.db.Exec(stmt); err != nil {
 			return err
 		}
-		return nil
+		return m.addColumns(ctx)
 	case 1:
 		return errors.New("invalid table schema")
 	}
@@ -56,6 +109,15 @@ func (m *Mysql) Migrate(ctx context.Context) error {
 id int PRIMARY KEY AUTO_INCREMENT,
 vulnerability_id varchar (128) NOT NULL,
 value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+title text,
+severity varchar (16) NOT NULL DEFAULT '',
+published_date datetime,
+last_modified_date datetime,
+cvss_v2_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v2_score double,
+cvss_v3_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v3_score double,
 created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) COMMENT = 'vulnerabilities obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.vulnerabilitiesTableName)
 
@@ -68,6 +130,21 @@ created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 		return err
 	}
 
+	stmt = fmt.Sprintf(`CREATE INDEX v_severity_idx ON %s(severity) USING BTREE;`, m.vulnerabilitiesTableName)
+	if _, err := m.db.Exec(stmt); err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf(`CREATE INDEX v_published_date_idx ON %s(published_date) USING BTREE;`, m.vulnerabilitiesTableName)
+	if _, err := m.db.Exec(stmt); err != nil {
+		return err
+	}
+
+	stmt = fmt.Sprintf(`CREATE INDEX v_last_modified_date_idx ON %s(last_modified_date) USING BTREE;`, m.vulnerabilitiesTableName)
+	if _, err := m.db.Exec(stmt); err != nil {
+		return err
+	}
+
 	stmt = fmt.Sprintf(`CREATE TABLE %s (
 id int PRIMARY KEY AUTO_INCREMENT,
 vulnerability_id varchar (128) NOT NULL,
@@ -75,6 +152,14 @@ platform varchar (50) NOT NULL,
 segment varchar (50) NOT NULL,
 package varchar (100) NOT NULL,
 value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+fixed_version varchar (255) NOT NULL DEFAULT '',
+affected_version varchar (255) NOT NULL DEFAULT '',
+vulnerable_versions json,
+patched_versions json,
+unaffected_versions json,
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
 created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) COMMENT = 'vulnerability advisories obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.advisoryTableName)
 
@@ -107,6 +192,11 @@ created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 		return err
 	}
 
+	stmt = fmt.Sprintf(`CREATE INDEX va_status_idx ON %s(status) USING BTREE;`, m.advisoryTableName)
+	if _, err := m.db.Exec(stmt); err != nil {
+		return err
+	}
+
 	// 创建 data_source 表
 	stmt = fmt.Sprintf(`CREATE TABLE %s (
 id int PRIMARY KEY AUTO_INCREMENT,
@@ -114,6 +204,7 @@ source_key varchar (128) NOT NULL,
 source_id varchar (128) NOT NULL,
 source_name varchar (128) NOT NULL,
 source_url varchar (128) NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
 created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) COMMENT = 'data sources via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.dataSourceTableName)
 
@@ -126,98 +217,680 @@ created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 		return err
 	}
 
+	for _, t := range m.vulnChildTables() {
+		if err := t.create(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (m *Mysql) InsertVuln(ctx context.Context, vulns [][][]byte) error {
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,value) VALUES (?,?)%s", m.vulnerabilitiesTableName,
-		strings.Repeat(", (?,?)", len(vulns)-1)) //nolint:gosec
+// addColumns adds the columns and indexes added after v2 to the existing tables.
+func (m *Mysql) addColumns(ctx context.Context) error {
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{m.vulnerabilitiesTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.dataSourceTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "title", "text"},
+		{m.vulnerabilitiesTableName, "severity", "varchar (16) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "published_date", "datetime"},
+		{m.vulnerabilitiesTableName, "last_modified_date", "datetime"},
+		{m.vulnerabilitiesTableName, "cvss_v2_vector", "varchar (128) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v2_score", "double"},
+		{m.vulnerabilitiesTableName, "cvss_v3_vector", "varchar (128) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v3_score", "double"},
+		{m.advisoryTableName, "fixed_version", "varchar (255) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "affected_version", "varchar (255) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "vulnerable_versions", "json"},
+		{m.advisoryTableName, "patched_versions", "json"},
+		{m.advisoryTableName, "unaffected_versions", "json"},
+		{m.advisoryTableName, "status", "varchar (32) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "severity", "varchar (16) NOT NULL DEFAULT ''"},
+	}
+	changed := map[string]bool{}
+	for _, c := range columns {
+		added, err := m.addColumnIfNotExists(ctx, c.table, c.name, c.definition)
+		if err != nil {
+			return err
+		}
+		changed[c.table] = changed[c.table] || added
+	}
+	for _, t := range m.vulnChildTables() {
+		exists, err := m.tableExists(ctx, t.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := t.create(ctx); err != nil {
+			return err
+		}
+		// rows of the new table are derived from vulnerabilities
+		changed[m.vulnerabilitiesTableName] = true
+	}
+	if err := m.resetHashes(ctx, changed); err != nil {
+		return err
+	}
 
-	ins, err := m.db.Prepare(query)
-	if err != nil {
+	indexes := []struct {
+		table   string
+		name    string
+		columns string
+	}{
+		{m.vulnerabilitiesTableName, "v_severity_idx", "severity"},
+		{m.vulnerabilitiesTableName, "v_published_date_idx", "published_date"},
+		{m.vulnerabilitiesTableName, "v_last_modified_date_idx", "last_modified_date"},
+		{m.advisoryTableName, "va_status_idx", "status"},
+	}
+	for _, idx := range indexes {
+		if err := m.createIndexIfNotExists(ctx, idx.table, idx.name, idx.columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mysql) addColumnIfNotExists(ctx context.Context, table, column, definition string) (bool, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = database() AND table_name = ? AND column_name = ?;"
+	if err := m.db.QueryRowContext(ctx, stmt, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Mysql) tableExists(ctx context.Context, table string) (bool, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = database() AND table_name = ?;"
+	if err := m.db.QueryRowContext(ctx, stmt, table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// resetHashes clears value_hash of the tables with added columns,
+// so that the next incremental load fills the new columns of all rows.
+func (m *Mysql) resetHashes(ctx context.Context, tables map[string]bool) error {
+	for table, changed := range tables {
+		if !changed {
+			continue
+		}
+		stmt := fmt.Sprintf("UPDATE %s SET value_hash = '';", table)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Mysql) createIndexIfNotExists(ctx context.Context, table, name, columns string) error {
+	var count int
+	stmt := "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = database() AND table_name = ? AND index_name = ?;"
+	if err := m.db.QueryRowContext(ctx, stmt, table, name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	stmt = fmt.Sprintf("CREATE INDEX %s ON %s(%s) USING BTREE;", name, table, columns)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Mysql) createMetadataTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY AUTO_INCREMENT,
+trivy_db_version int NOT NULL,
+updated_at datetime,
+next_update datetime,
+downloaded_at datetime,
+trivy_db_to_version varchar (32) NOT NULL,
+row_counts json NOT NULL,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) COMMENT = 'Trivy DB metadata recorded per run' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.metadataTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	var values []interface{}
-	for _, vuln := range vulns {
-		values = append(values, vuln[0], vuln[1])
+func (m *Mysql) createChangesTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigint PRIMARY KEY AUTO_INCREMENT,
+run_id char (36) NOT NULL,
+recorded_at datetime NOT NULL,
+change_type varchar (16) NOT NULL,
+vulnerability_id varchar (128) NOT NULL,
+platform varchar (50) NOT NULL,
+segment varchar (50) NOT NULL,
+package varchar (100) NOT NULL,
+fixed_version varchar (255) NOT NULL DEFAULT '',
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
+INDEX vch_run_id_idx (run_id) USING BTREE,
+INDEX vch_vulnerability_id_idx (vulnerability_id) USING BTREE,
+INDEX vch_source_package_idx (platform, segment, package) USING BTREE,
+INDEX vch_recorded_at_idx (recorded_at) USING BTREE
+) COMMENT = 'vulnerability advisories added, removed or modified by each run' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.changesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Mysql) createCVSSTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY AUTO_INCREMENT,
+vulnerability_id varchar (128) NOT NULL,
+source varchar (64) NOT NULL,
+v2_vector varchar (128) NOT NULL DEFAULT '',
+v2_score double,
+v3_vector varchar (128) NOT NULL DEFAULT '',
+v3_score double,
+v4_vector varchar (255) NOT NULL DEFAULT '',
+v4_score double,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+INDEX vc_vulnerability_id_idx (vulnerability_id) USING BTREE,
+INDEX vc_source_idx (source) USING BTREE
+) COMMENT = 'CVSS per vulnerability and source obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.cvssTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
 	}
-	{
-		_, err := ins.Exec(values...)
+	return nil
+}
+
+func (m *Mysql) createReferencesTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY AUTO_INCREMENT,
+vulnerability_id varchar (128) NOT NULL,
+url text NOT NULL,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+INDEX vr_vulnerability_id_idx (vulnerability_id) USING BTREE
+) COMMENT = 'vulnerability references obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.referencesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
+
+func (m *Mysql) createCWEsTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY AUTO_INCREMENT,
+vulnerability_id varchar (128) NOT NULL,
+cwe_id varchar (32) NOT NULL,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+INDEX vcw_vulnerability_id_idx (vulnerability_id) USING BTREE,
+INDEX vcw_cwe_id_idx (cwe_id) USING BTREE
+) COMMENT = 'vulnerability CWE IDs obtained via Trivy DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.cwesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Mysql) MigrateJavaDB(ctx context.Context) error {
+	if err := m.createJavaArtifactsTable(ctx); err != nil {
+		return err
+	}
+	return m.createJavaIndicesTable(ctx)
+}
+
+func (m *Mysql) createJavaArtifactsTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY,
+group_id varchar (255) NOT NULL,
+artifact_id varchar (255) NOT NULL,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+INDEX ja_group_id_artifact_id_idx (group_id, artifact_id) USING BTREE
+) COMMENT = 'Maven artifacts obtained via Trivy Java DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.javaArtifactsTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Mysql) createJavaIndicesTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id int PRIMARY KEY AUTO_INCREMENT,
+artifact_id int NOT NULL,
+version text NOT NULL,
+sha1 char (40) NOT NULL,
+archive_type varchar (16) NOT NULL,
+created_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+INDEX ji_artifact_id_idx (artifact_id) USING BTREE,
+INDEX ji_sha1_idx (sha1) USING BTREE
+) COMMENT = 'SHA1 digests of Maven artifact versions obtained via Trivy Java DB' ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, m.javaIndicesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+type vulnChildTable struct {
+	name   string
+	create func(ctx context.Context) error
+}
+
+// vulnChildTables returns the tables whose rows are derived from vulnerabilities.
+func (m *Mysql) vulnChildTables() []vulnChildTable {
+	return []vulnChildTable{
+		{m.cvssTableName, m.createCVSSTable},
+		{m.referencesTableName, m.createReferencesTable},
+		{m.cwesTableName, m.createCWEsTable},
+	}
+}
+
+func (m *Mysql) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	rows, err := vulnerabilityRows(vulns)
+	if err != nil {
+		return err
+	}
+	return m.insert(ctx, m.vulnerabilitiesTableName, vulnerabilityInsertColumns, rows)
+}
+
+// vulnerabilityRows returns the rows of vulnerabilityInsertColumns.
+func vulnerabilityRows(vulns [][][]byte) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, append([]interface{}{vuln[0], vuln[1], drivers.Hash(vuln[1])}, vulnerabilityValues(v)...))
+	}
+	return rows, nil
 }
 
 func (m *Mysql) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,platform,segment,package,value) VALUES (?,?,?,?,?)%s", m.advisoryTableName, strings.Repeat(", (?,?,?,?,?)", len(secAdvisories)-1)) //nolint:gosec
-	ins, err := m.db.Prepare(query)
+	rows, err := advisoryRows(secAdvisories)
 	if err != nil {
 		return err
 	}
+	return m.insert(ctx, m.advisoryTableName, advisoryInsertColumns, rows)
+}
 
-	var values []interface{}
+// advisoryRows returns the rows of advisoryInsertColumns.
+func advisoryRows(secAdvisories [][][]byte) ([][]interface{}, error) {
+	var rows [][]interface{}
 	for _, secAdvisory := range secAdvisories {
-		values = append(values, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3], secAdvisory[4])
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, append([]interface{}{secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3], secAdvisory[4], drivers.Hash(secAdvisory[4])},
+			advisoryValues(a)...))
 	}
-	{
-		_, err := ins.Exec(values...)
-		return err
+	return rows, nil
+}
+
+func (m *Mysql) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var rows [][]interface{}
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, append([]interface{}{c[0], c[1]}, cvssValues(v)...))
+	}
+	return m.insert(ctx, m.cvssTableName, cvssInsertColumns, rows)
+}
+
+func (m *Mysql) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.referencesTableName, "url", references)
+}
+
+func (m *Mysql) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.cwesTableName, "cwe_id", cwes)
+}
+
+// insertVulnChildRows inserts rows of [vulnerability_id, column] into table.
+func (m *Mysql) insertVulnChildRows(ctx context.Context, table, column string, rows [][][]byte) error {
+	return m.insertRows(ctx, table, []string{"vulnerability_id", column}, rows)
+}
+
+func (m *Mysql) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	return m.insertRows(ctx, m.javaArtifactsTableName, []string{"id", "group_id", "artifact_id"}, artifacts)
+}
+
+func (m *Mysql) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	return m.insertRows(ctx, m.javaIndicesTableName, []string{"artifact_id", "version", "sha1", "archive_type"}, indices)
+}
+
+// insertRows inserts rows holding the values of columns as they are into table.
+func (m *Mysql) insertRows(ctx context.Context, table string, columns []string, rows [][][]byte) error {
+	var values [][]interface{}
+	for _, r := range rows {
+		v := make([]interface{}, len(r))
+		for i, c := range r {
+			v[i] = c
+		}
+		values = append(values, v)
 	}
+	return m.insert(ctx, table, columns, values)
 }
 
 func (m *Mysql) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
-	// 定义一个结构体用于反序列化 JSON
-	type Item struct {
-		ID   string `json:"ID"`
-		Name string `json:"Name"`
-		URL  string `json:"URL"`
+	var rows [][]interface{}
+	for _, dataSource := range dataSources {
+		// 反序列化 JSON 到结构体
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		rows = append(rows, []interface{}{dataSource[0], item.ID, item.Name, item.URL, drivers.Hash(dataSource[1])})
 	}
+	return m.insert(ctx, m.dataSourceTableName, []string{"source_key", "source_id", "source_name", "source_url", "value_hash"}, rows)
+}
 
-	query := fmt.Sprintf("INSERT INTO %s(source_key,source_id,source_name,source_url) VALUES (?,?,?,?)%s", m.dataSourceTableName,
-		strings.Repeat(", (?,?,?,?)", len(dataSources)-1)) //nolint:gosec
+// insert writes rows into table with LOAD DATA LOCAL INFILE if enabled, otherwise with multi-row INSERTs.
+// If the server disallows local infile, it falls back to INSERT for the rest of the load.
+func (m *Mysql) insert(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	if m.localInfile {
+		err := m.loadData(ctx, table, columns, rows)
+		if !localInfileDisabled(err) {
+			return err
+		}
+		m.localInfile = false
+	}
+	p := placeholders(len(columns))
+	for _, c := range lo.Chunk(rows, maxPlaceholders/len(columns)) {
+		query := fmt.Sprintf("INSERT INTO %s(%s) VALUES %s%s", m.tableName(table), strings.Join(columns, ","), p, strings.Repeat(", "+p, len(c)-1)) //nolint:gosec
+		var values []interface{}
+		for _, r := range c {
+			values = append(values, r...)
+		}
+		if _, err := m.db.ExecContext(ctx, query, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	ins, err := m.db.Prepare(query)
+func (m *Mysql) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
 	if err != nil {
 		return err
 	}
+	stmt := fmt.Sprintf("INSERT INTO %s(trivy_db_version,updated_at,next_update,downloaded_at,trivy_db_to_version,row_counts) VALUES (?,?,?,?,?,?);", m.metadataTableName) //nolint:gosec
+	if _, err := m.db.ExecContext(ctx, stmt, metadata.Version, drivers.NullTime(metadata.UpdatedAt), drivers.NullTime(metadata.NextUpdate),
+		drivers.NullTime(metadata.DownloadedAt), metadata.TrivyDBToVersion, string(counts)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Mysql) InsertVulnAdvisoryChanges(ctx context.Context, runID string, recordedAt time.Time, changes [][][]byte) error {
+	var rows [][]interface{}
+	for _, c := range changes {
+		a, err := drivers.ParseAdvisoryChange(c)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []interface{}{runID, recordedAt.UTC(), c[0], c[1], c[2], c[3], c[4], a.FixedVersion, a.Status, a.Severity})
+	}
+	return m.insert(ctx, m.changesTableName, changeInsertColumns, rows)
+}
 
-	var values []interface{}
+func (m *Mysql) VulnHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s;", m.vulnerabilitiesTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Mysql) VulnAdvisoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s;", m.advisoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Mysql) DataSourceHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT source_key, value_hash FROM %s;", m.dataSourceTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Mysql) UpdateVuln(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = ?, value_hash = ?, title = ?, severity = ?, published_date = ?, last_modified_date = ?, "+
+		"cvss_v2_vector = ?, cvss_v2_score = ?, cvss_v3_vector = ?, cvss_v3_score = ? WHERE vulnerability_id = ?;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{vuln[1], drivers.Hash(vuln[1])}, vulnerabilityValues(v)...)
+		args = append(args, append(a, vuln[0]))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Mysql) UpdateVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = ?, value_hash = ?, fixed_version = ?, affected_version = ?, vulnerable_versions = ?, patched_versions = ?, "+
+		"unaffected_versions = ?, status = ?, severity = ? WHERE vulnerability_id = ? AND platform = ? AND segment = ? AND package = ?;", m.advisoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		adv, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{secAdvisory[4], drivers.Hash(secAdvisory[4])}, advisoryValues(adv)...)
+		args = append(args, append(a, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
 
+func (m *Mysql) UpdateDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET source_id = ?, source_name = ?, source_url = ?, value_hash = ? WHERE source_key = ?;", m.dataSourceTableName)
+	var args [][]interface{}
 	for _, dataSource := range dataSources {
-		// 反序列化 JSON 到结构体
-		var item Item
-		if err = json.Unmarshal(dataSource[1], &item); err != nil {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
 			return err
 		}
-		values = append(values, dataSource[0], item.ID, item.Name, item.URL)
+		args = append(args, []interface{}{item.ID, item.Name, item.URL, drivers.Hash(dataSource[1]), dataSource[0]})
 	}
-	{
-		_, err = ins.Exec(values...)
-		return err
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Mysql) DeleteVulns(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = ?;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{vuln[0]})
 	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
 }
 
-func (m *Mysql) TruncateVulns(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s;", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Mysql) DeleteVulnAdvisories(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = ? AND platform = ? AND segment = ? AND package = ?;", m.advisoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Mysql) DeleteDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE source_key = ?;", m.dataSourceTableName)
+	var args [][]interface{}
+	for _, dataSource := range dataSources {
+		args = append(args, []interface{}{dataSource[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Mysql) DeleteVulnCVSS(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cvssTableName, vulns)
+}
+
+func (m *Mysql) DeleteVulnReferences(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.referencesTableName, vulns)
+}
+
+func (m *Mysql) DeleteVulnCWEs(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cwesTableName, vulns)
+}
+
+// deleteVulnChildRows deletes the rows of vulnerabilities from table.
+func (m *Mysql) deleteVulnChildRows(ctx context.Context, table string, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = ?;", table)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{vuln[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+// CreateShadowTables creates the shadow tables. MySQL cannot rename tables in a transaction, so the changes table
+// gets a shadow too: it starts as a copy of the changes table, and the changes of the load are appended to it.
+func (m *Mysql) CreateShadowTables(ctx context.Context) error {
+	if err := m.createShadowTables(ctx, m.tables()); err != nil {
+		return err
+	}
+	if m.changesTableName == "" {
+		return nil
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s SELECT * FROM %s;", drivers.ShadowName(m.changesTableName), m.changesTableName) //nolint:gosec
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Mysql) TruncateVulnAdvisories(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s;", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+// SwapTables swaps all tables in a single RENAME TABLE statement, which MySQL executes atomically.
+func (m *Mysql) SwapTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.tables())
+}
+
+func (m *Mysql) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.tables())
+}
+
+func (m *Mysql) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Mysql) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.javaDBTables())
+}
+
+func (m *Mysql) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Mysql) createShadowTables(ctx context.Context, tables []string) error {
+	if err := m.dropShadowTables(ctx, tables); err != nil {
 		return err
 	}
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.OldName(t))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		stmt = fmt.Sprintf("CREATE TABLE %s LIKE %s;", drivers.ShadowName(t), t)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	m.shadow = true
 	return nil
 }
 
-// TruncateDataSource 清空数据源表的数据（使用 TRUNCATE 语句）。
-func (m *Mysql) TruncateDataSource(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s;", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Mysql) swapTables(ctx context.Context, tables []string) error {
+	var renames []string
+	for _, t := range tables {
+		renames = append(renames, fmt.Sprintf("%s TO %s, %s TO %s", t, drivers.OldName(t), drivers.ShadowName(t), t))
+	}
+	stmt := fmt.Sprintf("RENAME TABLE %s;", strings.Join(renames, ", "))
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	m.shadow = false
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.OldName(t))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+func (m *Mysql) dropShadowTables(ctx context.Context, tables []string) error {
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.ShadowName(t))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	m.shadow = false
+	return nil
+}
+
+// vulnerabilityValues returns the values of vulnerabilityColumns.
+func vulnerabilityValues(v drivers.Vulnerability) []interface{} {
+	return []interface{}{v.Title, v.Severity, v.PublishedDate, v.LastModifiedDate, v.CVSSV2Vector, v.CVSSV2Score, v.CVSSV3Vector, v.CVSSV3Score}
+}
+
+// advisoryValues returns the values of advisoryColumns.
+func advisoryValues(a drivers.Advisory) []interface{} {
+	return []interface{}{a.FixedVersion, a.AffectedVersion, drivers.JSONArray(a.VulnerableVersions), drivers.JSONArray(a.PatchedVersions),
+		drivers.JSONArray(a.UnaffectedVersions), a.Status, a.Severity}
+}
+
+// cvssValues returns the values of cvssColumns.
+func cvssValues(c drivers.CVSS) []interface{} {
+	return []interface{}{c.V2Vector, c.V2Score, c.V3Vector, c.V3Score, c.V4Vector, c.V4Score}
+}
+
+// placeholders returns "(?,...,?)" with n placeholders.
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", n), ",") + ")"
+}
+
+// tables returns the tables swapped by a load of Trivy DB, which include the changes table if changes are recorded.
+func (m *Mysql) tables() []string {
+	tables := []string{m.vulnerabilitiesTableName, m.advisoryTableName, m.dataSourceTableName, m.cvssTableName, m.referencesTableName, m.cwesTableName}
+	if m.changesTableName != "" {
+		tables = append(tables, m.changesTableName)
+	}
+	return tables
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Mysql) javaDBTables() []string {
+	return []string{m.javaArtifactsTableName, m.javaIndicesTableName}
+}
+
+func (m *Mysql) tableName(name string) string {
+	if m.shadow {
+		return drivers.ShadowName(name)
+	}
+	return name
+}
@@ -0,0 +1,519 @@
+package osv
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/trivy-db/pkg/types"
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const (
+	schemaVersion = "1.6.0"
+	docExt        = ".json"
+	zipExt        = ".zip"
+	// rangeType is the type of the ranges of affected, whose versions are compared as the ecosystem does.
+	rangeType = "ECOSYSTEM"
+)
+
+// Vulnerability is a document of the OSV schema (https://ossf.github.io/osv-schema/).
+type Vulnerability struct {
+	SchemaVersion    string                 `json:"schema_version"`
+	ID               string                 `json:"id"`
+	Modified         time.Time              `json:"modified"`
+	Published        *time.Time             `json:"published,omitempty"`
+	Summary          string                 `json:"summary,omitempty"`
+	Details          string                 `json:"details,omitempty"`
+	Severity         []Severity             `json:"severity,omitempty"`
+	Affected         []Affected             `json:"affected,omitempty"`
+	References       []Reference            `json:"references,omitempty"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+type Severity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+type Affected struct {
+	Package          Package                `json:"package"`
+	Ranges           []Range                `json:"ranges,omitempty"`
+	Versions         []string               `json:"versions,omitempty"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific,omitempty"`
+}
+
+type Package struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+}
+
+type Range struct {
+	Type   string  `json:"type"`
+	Events []Event `json:"events"`
+}
+
+type Event struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+}
+
+type Reference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// OSV writes a document of the OSV schema per vulnerability, <id>.json, into a directory, or into a zip archive
+// if the path ends with .zip as the ecosystem dumps of OSV. The advisories of a vulnerability are its affected packages.
+//
+// The documents are written when the vulnerabilities are inserted. The advisories of Trivy DB are ordered by data
+// source and package rather than by vulnerability, so the affected packages of all advisories of a load are held in
+// memory until the swap, which rewrites every document with them: the memory of a load grows with the number of
+// advisories. The other tables are not written.
+type OSV struct {
+	path string
+	// dir is the directory of the documents of the running load
+	dir string
+	// affected holds the affected packages of the running load by vulnerability ID
+	affected map[string][]Affected
+	// loadedAt is the modified time of the documents whose vulnerability has no date
+	loadedAt time.Time
+}
+
+// New return *OSV
+func New(path string) (*OSV, error) {
+	if path == "" {
+		return nil, errors.New("no path to write OSV documents to")
+	}
+	return &OSV{
+		path:     path,
+		dir:      drivers.ShadowName(strings.TrimSuffix(path, zipExt)),
+		affected: map[string][]Affected{},
+	}, nil
+}
+
+func (m *OSV) isZip() bool {
+	return filepath.Ext(m.path) == zipExt
+}
+
+func (m *OSV) Migrate(ctx context.Context) error {
+	return nil
+}
+
+func (m *OSV) CreateShadowTables(ctx context.Context) error {
+	if err := m.DropShadowTables(ctx); err != nil {
+		return err
+	}
+	m.loadedAt = time.Now().UTC().Truncate(time.Second)
+	return os.MkdirAll(m.dir, 0755) //nolint:gosec
+}
+
+// SwapTables adds the affected packages to the documents and replaces the directory or the zip archive with them.
+func (m *OSV) SwapTables(ctx context.Context) error {
+	for id, affected := range m.affected {
+		doc, err := m.readDoc(id)
+		if err != nil {
+			return err
+		}
+		doc.Affected = affected
+		if err := m.writeDoc(doc); err != nil {
+			return err
+		}
+	}
+	m.affected = map[string][]Affected{}
+	if !m.isZip() {
+		if err := os.RemoveAll(m.path); err != nil {
+			return err
+		}
+		return os.Rename(m.dir, m.path)
+	}
+	shadow := drivers.ShadowName(strings.TrimSuffix(m.path, zipExt)) + zipExt
+	if err := writeZip(shadow, m.dir, m.loadedAt); err != nil {
+		_ = os.Remove(shadow)
+		return err
+	}
+	if err := os.Rename(shadow, m.path); err != nil {
+		return err
+	}
+	return os.RemoveAll(m.dir)
+}
+
+func (m *OSV) DropShadowTables(ctx context.Context) error {
+	m.affected = map[string][]Affected{}
+	var errs []error
+	errs = append(errs, os.RemoveAll(m.dir))
+	if m.isZip() {
+		shadow := drivers.ShadowName(strings.TrimSuffix(m.path, zipExt)) + zipExt
+		if err := os.Remove(shadow); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// docPath returns the path of the document of id. Path separators of IDs are replaced.
+func (m *OSV) docPath(id string) string {
+	return filepath.Join(m.dir, strings.ReplaceAll(id, "/", "_")+docExt)
+}
+
+// readDoc reads the document of id, or returns a document without details if the vulnerability bucket has no entry of id.
+func (m *OSV) readDoc(id string) (Vulnerability, error) {
+	doc := Vulnerability{SchemaVersion: schemaVersion, ID: id, Modified: m.loadedAt}
+	b, err := os.ReadFile(m.docPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return doc, nil
+		}
+		return doc, err
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return doc, err
+	}
+	return doc, nil
+}
+
+func (m *OSV) writeDoc(doc Vulnerability) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return os.WriteFile(m.docPath(doc.ID), buf.Bytes(), 0644) //nolint:gosec
+}
+
+// writeZip writes the documents in dir into the zip archive at path, modified at modified.
+func writeZip(path, dir string, modified time.Time) (err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name(), Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, src)
+		if cerr := src.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (m *OSV) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	for _, vuln := range vulns {
+		doc, err := newVulnerability(string(vuln[0]), vuln[1], m.loadedAt)
+		if err != nil {
+			return err
+		}
+		if err := m.writeDoc(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newVulnerability returns the document of a value of the vulnerability bucket.
+// modified is used if the vulnerability has neither a last modified date nor a published date.
+func newVulnerability(id string, value []byte, modified time.Time) (Vulnerability, error) {
+	v, err := drivers.ParseVulnerability(value)
+	if err != nil {
+		return Vulnerability{}, err
+	}
+	var raw types.Vulnerability
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return Vulnerability{}, err
+	}
+	doc := Vulnerability{
+		SchemaVersion: schemaVersion,
+		ID:            id,
+		Modified:      modified,
+		Summary:       v.Title,
+		Details:       raw.Description,
+	}
+	if v.PublishedDate.Valid {
+		t := v.PublishedDate.Time.UTC()
+		doc.Published = &t
+		doc.Modified = t
+	}
+	if v.LastModifiedDate.Valid {
+		doc.Modified = v.LastModifiedDate.Time.UTC()
+	}
+	cvss, err := drivers.CVSSRows([][][]byte{{[]byte(id), value}})
+	if err != nil {
+		return Vulnerability{}, err
+	}
+	var v4Vector string
+	for _, row := range cvss {
+		c, err := drivers.ParseCVSS(row[2])
+		if err != nil {
+			return Vulnerability{}, err
+		}
+		if c.V4Vector != "" {
+			v4Vector = c.V4Vector
+			break
+		}
+	}
+	for _, s := range []Severity{{"CVSS_V4", v4Vector}, {"CVSS_V3", v.CVSSV3Vector}, {"CVSS_V2", v.CVSSV2Vector}} {
+		if s.Score != "" {
+			doc.Severity = append(doc.Severity, s)
+		}
+	}
+	for _, u := range raw.References {
+		doc.References = append(doc.References, Reference{Type: "WEB", URL: u})
+	}
+	specific := map[string]interface{}{}
+	if v.Severity != "" {
+		specific["severity"] = v.Severity
+	}
+	if len(raw.CweIDs) > 0 {
+		specific["cwe_ids"] = raw.CweIDs
+	}
+	if len(specific) > 0 {
+		doc.DatabaseSpecific = specific
+	}
+	return doc, nil
+}
+
+func (m *OSV) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	for _, secAdvisory := range secAdvisories {
+		affected, ok, err := newAffected(string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]), secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		id := string(secAdvisory[0])
+		m.affected[id] = append(m.affected[id], affected)
+	}
+	return nil
+}
+
+// newAffected returns the affected package of an advisory. It returns false if the package is not affected.
+func newAffected(platform, segment, pkg string, value []byte) (Affected, bool, error) {
+	a, err := drivers.ParseAdvisory(value)
+	if err != nil {
+		return Affected{}, false, err
+	}
+	if a.Status == "not_affected" {
+		return Affected{}, false, nil
+	}
+	affected := Affected{
+		Package: Package{Ecosystem: Ecosystem(platform, segment), Name: pkg},
+	}
+	specific := map[string]interface{}{"source": strings.TrimSpace(platform + " " + segment)}
+	if a.Status != "" {
+		specific["status"] = a.Status
+	}
+	if a.Severity != "" {
+		specific["severity"] = a.Severity
+	}
+	switch {
+	case len(a.VulnerableVersions) > 0:
+		// the constraints are kept as is, because not every constraint can be written as events
+		specific["vulnerable_versions"] = a.VulnerableVersions
+		if len(a.PatchedVersions) > 0 {
+			specific["patched_versions"] = a.PatchedVersions
+		}
+		for _, c := range a.VulnerableVersions {
+			ranges, versions, ok := parseConstraint(c)
+			if !ok {
+				continue
+			}
+			affected.Ranges = append(affected.Ranges, ranges...)
+			affected.Versions = append(affected.Versions, versions...)
+		}
+	case a.FixedVersion != "":
+		affected.Ranges = []Range{{Type: rangeType, Events: []Event{{Introduced: "0"}, {Fixed: a.FixedVersion}}}}
+	default:
+		// no fix is available, so every version is affected
+		affected.Ranges = []Range{{Type: rangeType, Events: []Event{{Introduced: "0"}}}}
+	}
+	affected.DatabaseSpecific = specific
+	return affected, true, nil
+}
+
+// operators are the characters of the operators of version constraints.
+const operators = "<>=!~^"
+
+// parseConstraint returns the ranges and the versions of a constraint of vulnerable versions such as ">= 1.0.0, < 1.2.3",
+// ">= 1.0 < 2.0 || >= 3.0" or "= 1.0.0". Each alternative separated by "||" is a range.
+// It returns false if the constraint has an operator that a range cannot express.
+func parseConstraint(constraint string) ([]Range, []string, bool) {
+	var (
+		ranges   []Range
+		versions []string
+	)
+	for _, alt := range strings.Split(constraint, "||") {
+		r, vs, ok := parseAlternative(alt)
+		if !ok {
+			return nil, nil, false
+		}
+		if len(r.Events) > 0 {
+			ranges = append(ranges, r)
+		}
+		versions = append(versions, vs...)
+	}
+	return ranges, versions, true
+}
+
+// parseAlternative returns the range and the versions of the operator and version pairs of an alternative,
+// which are separated by commas or spaces. An operator may be separated from its version by a space.
+func parseAlternative(alt string) (Range, []string, bool) {
+	var (
+		introduced, fixed, lastAffected string
+		versions                        []string
+	)
+	fields := strings.Fields(strings.ReplaceAll(alt, ",", " "))
+	if len(fields) == 0 {
+		return Range{}, nil, false
+	}
+	for i := 0; i < len(fields); i++ {
+		version := strings.TrimLeft(fields[i], operators)
+		op := fields[i][:len(fields[i])-len(version)]
+		if version == "" && i+1 < len(fields) {
+			i++
+			version = fields[i]
+		}
+		if version == "" || strings.ContainsAny(version, operators+" ") {
+			return Range{}, nil, false
+		}
+		switch op {
+		case ">=":
+			introduced = version
+		case "<":
+			fixed = version
+		case "<=":
+			lastAffected = version
+		case "=", "==", "":
+			versions = append(versions, version)
+		default:
+			return Range{}, nil, false
+		}
+	}
+	if fixed == "" && lastAffected == "" {
+		if introduced != "" {
+			// every version from introduced is affected
+			return Range{Type: rangeType, Events: []Event{{Introduced: introduced}}}, versions, true
+		}
+		return Range{}, versions, true
+	}
+	if introduced == "" {
+		introduced = "0"
+	}
+	events := []Event{{Introduced: introduced}}
+	if fixed != "" {
+		events = append(events, Event{Fixed: fixed})
+	} else {
+		events = append(events, Event{LastAffected: lastAffected})
+	}
+	return Range{Type: rangeType, Events: events}, versions, true
+}
+
+// languageEcosystems are the OSV ecosystems of the prefixes of the advisory buckets of language packages such as "npm::GitHub Security Advisory npm".
+var languageEcosystems = map[string]string{
+	"bitnami":   "Bitnami",
+	"cargo":     "crates.io",
+	"cocoapods": "CocoaPods",
+	"composer":  "Packagist",
+	"conan":     "ConanCenter",
+	"erlang":    "Hex",
+	"go":        "Go",
+	"maven":     "Maven",
+	"npm":       "npm",
+	"nuget":     "NuGet",
+	"pip":       "PyPI",
+	"pub":       "Pub",
+	"rubygems":  "RubyGems",
+	"swift":     "SwiftURL",
+}
+
+// osEcosystems are the OSV ecosystems of the platforms of the advisory buckets of OS packages, which are suffixed with ":<segment>".
+var osEcosystems = map[string]string{
+	"alma":      "AlmaLinux",
+	"alpine":    "Alpine",
+	"debian":    "Debian",
+	"photon os": "Photon OS",
+	"rocky":     "Rocky Linux",
+	"ubuntu":    "Ubuntu",
+}
+
+// unversionedEcosystems are the OSV ecosystems of the rolling platforms, which have no segment.
+var unversionedEcosystems = map[string]string{
+	"chainguard": "Chainguard",
+	"wolfi":      "Wolfi",
+}
+
+// Ecosystem returns the OSV ecosystem of the platform and the segment of an advisory bucket.
+// Platforms unknown to OSV are returned as "<platform>:<segment>".
+func Ecosystem(platform, segment string) string {
+	if prefix, _, ok := strings.Cut(platform, "::"); ok {
+		if e, ok := languageEcosystems[prefix]; ok {
+			return e
+		}
+		return prefix
+	}
+	p := strings.ToLower(platform)
+	if e, ok := unversionedEcosystems[p]; ok {
+		return e
+	}
+	if segment == "" {
+		return platform
+	}
+	e, ok := osEcosystems[p]
+	if !ok {
+		return fmt.Sprintf("%s:%s", platform, segment)
+	}
+	if p == "alpine" && segment != "edge" {
+		// OSV names the releases of Alpine as v3.18
+		segment = "v" + segment
+	}
+	return e + ":" + segment
+}
+
+func (m *OSV) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	return nil
+}
+
+func (m *OSV) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	return nil
+}
+
+func (m *OSV) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return nil
+}
+
+func (m *OSV) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return nil
+}
+
+func (m *OSV) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	return nil
+}
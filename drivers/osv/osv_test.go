@@ -0,0 +1,83 @@
+package osv
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEcosystem(t *testing.T) {
+	tests := []struct {
+		platform string
+		segment  string
+		want     string
+	}{
+		{"debian", "12", "Debian:12"},
+		{"alpine", "3.18", "Alpine:v3.18"},
+		{"wolfi", "", "Wolfi"},
+		{"npm::GitHub Security Advisory npm", "", "npm"},
+		{"pip::GitLab Advisory Database Community", "", "PyPI"},
+		{"amazon linux", "2", "amazon linux:2"},
+	}
+	for _, tt := range tests {
+		if got := Ecosystem(tt.platform, tt.segment); got != tt.want {
+			t.Errorf("Ecosystem(%q, %q) = %q, want %q", tt.platform, tt.segment, got, tt.want)
+		}
+	}
+}
+
+func TestNewAffected(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{
+			`{"FixedVersion":"3.0.9-1","Severity":3}`,
+			`{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.0.9-1"}]}],` +
+				`"database_specific":{"severity":"HIGH","source":"debian 12","status":"fixed"}}`,
+		},
+		{
+			`{"Status":2}`,
+			`{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"}]}],` +
+				`"database_specific":{"source":"debian 12","status":"affected"}}`,
+		},
+		{
+			`{"VulnerableVersions":[">= 3.0.0, <= 3.0.8","= 1.1.1",">1.0.0"]}`,
+			`{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"3.0.0"},{"last_affected":"3.0.8"}]}],"versions":["1.1.1"],` +
+				`"database_specific":{"source":"debian 12","vulnerable_versions":[">= 3.0.0, <= 3.0.8","= 1.1.1",">1.0.0"]}}`,
+		},
+		{
+			`{"VulnerableVersions":[">= 1.0 < 2.0 || >=3.0, <3.2","<1.0.0 || = 2.1.0"]}`,
+			`{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"1.0"},{"fixed":"2.0"}]},` +
+				`{"type":"ECOSYSTEM","events":[{"introduced":"3.0"},{"fixed":"3.2"}]},{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"1.0.0"}]}],"versions":["2.1.0"],` +
+				`"database_specific":{"source":"debian 12","vulnerable_versions":[">= 1.0 < 2.0 || >=3.0, <3.2","<1.0.0 || = 2.1.0"]}}`,
+		},
+		{
+			`{"VulnerableVersions":[">= 1.0 || > 2.0","1.0<2.0","< 1.0 ||"]}`,
+			`{"package":{"ecosystem":"Debian:12","name":"openssl"},` +
+				`"database_specific":{"source":"debian 12","vulnerable_versions":[">= 1.0 || > 2.0","1.0<2.0","< 1.0 ||"]}}`,
+		},
+	}
+	for _, tt := range tests {
+		a, ok, err := newAffected("debian", "12", "openssl", []byte(tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("%s is not affected", tt.value)
+		}
+		var b strings.Builder
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(a); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(b.String(), "\n"); got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+
+	if _, ok, err := newAffected("debian", "12", "openssl", []byte(`{"Status":1}`)); err != nil || ok {
+		t.Errorf("a package not affected is affected: %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package parquet
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	parquetgo "github.com/parquet-go/parquet-go"
+)
+
+const ext = ".parquet"
+
+type vulnerabilityRow struct {
+	VulnerabilityID  string     `parquet:"vulnerability_id"`
+	Value            string     `parquet:"value,json"`
+	ValueHash        string     `parquet:"value_hash"`
+	Title            string     `parquet:"title"`
+	Severity         string     `parquet:"severity"`
+	PublishedDate    *time.Time `parquet:"published_date,optional"`
+	LastModifiedDate *time.Time `parquet:"last_modified_date,optional"`
+	CVSSV2Vector     string     `parquet:"cvss_v2_vector"`
+	CVSSV2Score      *float64   `parquet:"cvss_v2_score,optional"`
+	CVSSV3Vector     string     `parquet:"cvss_v3_vector"`
+	CVSSV3Score      *float64   `parquet:"cvss_v3_score,optional"`
+}
+
+type advisoryRow struct {
+	VulnerabilityID    string   `parquet:"vulnerability_id"`
+	Platform           string   `parquet:"platform"`
+	Segment            string   `parquet:"segment"`
+	Package            string   `parquet:"package"`
+	Value              string   `parquet:"value,json"`
+	ValueHash          string   `parquet:"value_hash"`
+	FixedVersion       string   `parquet:"fixed_version"`
+	AffectedVersion    string   `parquet:"affected_version"`
+	VulnerableVersions []string `parquet:"vulnerable_versions,list"`
+	PatchedVersions    []string `parquet:"patched_versions,list"`
+	UnaffectedVersions []string `parquet:"unaffected_versions,list"`
+	Status             string   `parquet:"status"`
+	Severity           string   `parquet:"severity"`
+}
+
+type dataSourceRow struct {
+	SourceKey  string `parquet:"source_key"`
+	SourceID   string `parquet:"source_id"`
+	SourceName string `parquet:"source_name"`
+	SourceURL  string `parquet:"source_url"`
+	ValueHash  string `parquet:"value_hash"`
+}
+
+type cvssRow struct {
+	VulnerabilityID string   `parquet:"vulnerability_id"`
+	Source          string   `parquet:"source"`
+	V2Vector        string   `parquet:"v2_vector"`
+	V2Score         *float64 `parquet:"v2_score,optional"`
+	V3Vector        string   `parquet:"v3_vector"`
+	V3Score         *float64 `parquet:"v3_score,optional"`
+	V4Vector        string   `parquet:"v4_vector"`
+	V4Score         *float64 `parquet:"v4_score,optional"`
+}
+
+type referenceRow struct {
+	VulnerabilityID string `parquet:"vulnerability_id"`
+	URL             string `parquet:"url"`
+}
+
+type cweRow struct {
+	VulnerabilityID string `parquet:"vulnerability_id"`
+	CWEID           string `parquet:"cwe_id"`
+}
+
+type javaArtifactRow struct {
+	ID         int64  `parquet:"id"`
+	GroupID    string `parquet:"group_id"`
+	ArtifactID string `parquet:"artifact_id"`
+}
+
+type javaIndexRow struct {
+	ArtifactID  int64  `parquet:"artifact_id"`
+	Version     string `parquet:"version"`
+	SHA1        string `parquet:"sha1"`
+	ArchiveType string `parquet:"archive_type"`
+}
+
+type metadataRow struct {
+	TrivyDBVersion   int        `parquet:"trivy_db_version"`
+	UpdatedAt        *time.Time `parquet:"updated_at,optional"`
+	NextUpdate       *time.Time `parquet:"next_update,optional"`
+	DownloadedAt     *time.Time `parquet:"downloaded_at,optional"`
+	TrivyDBToVersion string     `parquet:"trivy_db_to_version"`
+	RowCounts        string     `parquet:"row_counts,json"`
+	CreatedAt        time.Time  `parquet:"created_at"`
+}
+
+// Parquet writes each table into a Parquet file <table>.parquet in a directory.
+// Advisories are optionally partitioned into <table>/platform=<platform>/part-0.parquet.
+// The files of a load are written next to the previous ones under shadow names and renamed on swap.
+type Parquet struct {
+	dir                      string
+	vulnerabilitiesTableName string
+	advisoryTableName        string
+	dataSourceTableName      string
+	metadataTableName        string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	partition                bool
+	writerOptions            []parquetgo.WriterOption
+	// writers holds the open writers of the running load by path
+	writers map[string]closer
+}
+
+// New return *Parquet
+func New(dir string, tableNames drivers.TableNames, opts drivers.Options) (*Parquet, error) {
+	if dir == "" {
+		return nil, errors.New("no directory to write Parquet files to")
+	}
+	wopts := []parquetgo.WriterOption{parquetgo.Compression(&parquetgo.Zstd)}
+	if opts.ParquetRowGroupSize > 0 {
+		wopts = append(wopts, parquetgo.MaxRowsPerRowGroup(opts.ParquetRowGroupSize))
+	}
+	return &Parquet{
+		dir:                      dir,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+		partition:                opts.ParquetPartitionByPlatform,
+		writerOptions:            wopts,
+		writers:                  map[string]closer{},
+	}, nil
+}
+
+type table struct {
+	name string
+	// partitioned tables are directories of Parquet files
+	partitioned bool
+	// create creates the writer of an unpartitioned table, so that tables without rows have a file too
+	create func(path string) error
+}
+
+func (m *Parquet) tables() []table {
+	return []table{
+		{m.vulnerabilitiesTableName, false, writerOf[vulnerabilityRow](m)},
+		{m.advisoryTableName, m.partition, writerOf[advisoryRow](m)},
+		{m.dataSourceTableName, false, writerOf[dataSourceRow](m)},
+		{m.cvssTableName, false, writerOf[cvssRow](m)},
+		{m.referencesTableName, false, writerOf[referenceRow](m)},
+		{m.cwesTableName, false, writerOf[cweRow](m)},
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Parquet) javaDBTables() []table {
+	return []table{
+		{m.javaArtifactsTableName, false, writerOf[javaArtifactRow](m)},
+		{m.javaIndicesTableName, false, writerOf[javaIndexRow](m)},
+	}
+}
+
+func (m *Parquet) Migrate(ctx context.Context) error {
+	return os.MkdirAll(m.dir, 0755) //nolint:gosec
+}
+
+func (m *Parquet) MigrateJavaDB(ctx context.Context) error {
+	return os.MkdirAll(m.dir, 0755) //nolint:gosec
+}
+
+func (m *Parquet) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.tables())
+}
+
+// SwapTables closes the files of the load and renames them to the names of the tables.
+func (m *Parquet) SwapTables(ctx context.Context) error {
+	return m.swapTables(m.tables())
+}
+
+func (m *Parquet) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.tables())
+}
+
+func (m *Parquet) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(m.javaDBTables())
+}
+
+func (m *Parquet) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(m.javaDBTables())
+}
+
+func (m *Parquet) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(m.javaDBTables())
+}
+
+func (m *Parquet) createShadowTables(tables []table) error {
+	if err := m.dropShadowTables(tables); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if t.partitioned {
+			if err := os.MkdirAll(m.shadowPath(t), 0755); err != nil { //nolint:gosec
+				return err
+			}
+			continue
+		}
+		if err := t.create(m.shadowPath(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Parquet) swapTables(tables []table) error {
+	if err := m.closeWriters(); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		// the table may have been written with or without partitioning before
+		for _, p := range []bool{false, true} {
+			if err := os.RemoveAll(m.path(table{name: t.name, partitioned: p})); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(m.shadowPath(t), m.path(t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Parquet) dropShadowTables(tables []table) error {
+	err := m.closeWriters()
+	for _, t := range tables {
+		if rerr := os.RemoveAll(m.shadowPath(t)); rerr != nil {
+			return rerr
+		}
+	}
+	return err
+}
+
+func (m *Parquet) closeWriters() error {
+	var errs []error
+	for path, w := range m.writers {
+		errs = append(errs, w.Close())
+		delete(m.writers, path)
+	}
+	return errors.Join(errs...)
+}
+
+// path returns the path of the file (or directory of a partitioned table) of t.
+func (m *Parquet) path(t table) string {
+	if t.partitioned {
+		return filepath.Join(m.dir, t.name)
+	}
+	return filepath.Join(m.dir, t.name+ext)
+}
+
+// shadowPath returns the path that the next generation of t is written to.
+func (m *Parquet) shadowPath(t table) string {
+	if t.partitioned {
+		return filepath.Join(m.dir, drivers.ShadowName(t.name))
+	}
+	return filepath.Join(m.dir, drivers.ShadowName(t.name)+ext)
+}
+
+func (m *Parquet) shadowFile(table string) string {
+	return filepath.Join(m.dir, drivers.ShadowName(table)+ext)
+}
+
+func (m *Parquet) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	var rows []vulnerabilityRow
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, vulnerabilityRow{
+			VulnerabilityID:  string(vuln[0]),
+			Value:            string(vuln[1]),
+			ValueHash:        drivers.Hash(vuln[1]),
+			Title:            v.Title,
+			Severity:         v.Severity,
+			PublishedDate:    nullTime(v.PublishedDate),
+			LastModifiedDate: nullTime(v.LastModifiedDate),
+			CVSSV2Vector:     v.CVSSV2Vector,
+			CVSSV2Score:      nullFloat(v.CVSSV2Score),
+			CVSSV3Vector:     v.CVSSV3Vector,
+			CVSSV3Score:      nullFloat(v.CVSSV3Score),
+		})
+	}
+	return writeRows(m, m.shadowFile(m.vulnerabilitiesTableName), rows)
+}
+
+func (m *Parquet) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	rows := map[string][]advisoryRow{}
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		path := m.shadowFile(m.advisoryTableName)
+		if m.partition {
+			// Hive-style partitioning
+			path = filepath.Join(m.dir, drivers.ShadowName(m.advisoryTableName), "platform="+url.PathEscape(string(secAdvisory[1])), "part-0"+ext)
+		}
+		rows[path] = append(rows[path], advisoryRow{
+			VulnerabilityID:    string(secAdvisory[0]),
+			Platform:           string(secAdvisory[1]),
+			Segment:            string(secAdvisory[2]),
+			Package:            string(secAdvisory[3]),
+			Value:              string(secAdvisory[4]),
+			ValueHash:          drivers.Hash(secAdvisory[4]),
+			FixedVersion:       a.FixedVersion,
+			AffectedVersion:    a.AffectedVersion,
+			VulnerableVersions: a.VulnerableVersions,
+			PatchedVersions:    a.PatchedVersions,
+			UnaffectedVersions: a.UnaffectedVersions,
+			Status:             a.Status,
+			Severity:           a.Severity,
+		})
+	}
+	for path, r := range rows {
+		if err := writeRows(m, path, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Parquet) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	var rows []dataSourceRow
+	for _, dataSource := range dataSources {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		rows = append(rows, dataSourceRow{
+			SourceKey:  string(dataSource[0]),
+			SourceID:   item.ID,
+			SourceName: item.Name,
+			SourceURL:  item.URL,
+			ValueHash:  drivers.Hash(dataSource[1]),
+		})
+	}
+	return writeRows(m, m.shadowFile(m.dataSourceTableName), rows)
+}
+
+func (m *Parquet) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var rows []cvssRow
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, cvssRow{
+			VulnerabilityID: string(c[0]),
+			Source:          string(c[1]),
+			V2Vector:        v.V2Vector,
+			V2Score:         nullFloat(v.V2Score),
+			V3Vector:        v.V3Vector,
+			V3Score:         nullFloat(v.V3Score),
+			V4Vector:        v.V4Vector,
+			V4Score:         nullFloat(v.V4Score),
+		})
+	}
+	return writeRows(m, m.shadowFile(m.cvssTableName), rows)
+}
+
+func (m *Parquet) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	var rows []referenceRow
+	for _, r := range references {
+		rows = append(rows, referenceRow{VulnerabilityID: string(r[0]), URL: string(r[1])})
+	}
+	return writeRows(m, m.shadowFile(m.referencesTableName), rows)
+}
+
+func (m *Parquet) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	var rows []cweRow
+	for _, r := range cwes {
+		rows = append(rows, cweRow{VulnerabilityID: string(r[0]), CWEID: string(r[1])})
+	}
+	return writeRows(m, m.shadowFile(m.cwesTableName), rows)
+}
+
+func (m *Parquet) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	var rows []javaArtifactRow
+	for _, r := range artifacts {
+		id, err := strconv.ParseInt(string(r[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, javaArtifactRow{ID: id, GroupID: string(r[1]), ArtifactID: string(r[2])})
+	}
+	return writeRows(m, m.shadowFile(m.javaArtifactsTableName), rows)
+}
+
+func (m *Parquet) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	var rows []javaIndexRow
+	for _, r := range indices {
+		id, err := strconv.ParseInt(string(r[0]), 10, 64)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, javaIndexRow{ArtifactID: id, Version: string(r[1]), SHA1: string(r[2]), ArchiveType: string(r[3])})
+	}
+	return writeRows(m, m.shadowFile(m.javaIndicesTableName), rows)
+}
+
+// InsertMetadata rewrites the metadata file with the metadata of the run appended.
+func (m *Parquet) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
+	if err != nil {
+		return err
+	}
+	t := table{name: m.metadataTableName}
+	rows, err := parquetgo.ReadFile[metadataRow](m.path(t))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := writeRows(m, m.shadowPath(t), append(rows, metadataRow{
+		TrivyDBVersion:   metadata.Version,
+		UpdatedAt:        nullTime(drivers.NullTime(metadata.UpdatedAt)),
+		NextUpdate:       nullTime(drivers.NullTime(metadata.NextUpdate)),
+		DownloadedAt:     nullTime(drivers.NullTime(metadata.DownloadedAt)),
+		TrivyDBToVersion: metadata.TrivyDBToVersion,
+		RowCounts:        string(counts),
+		CreatedAt:        time.Now().UTC(),
+	})); err != nil {
+		return err
+	}
+	return m.swapTables([]table{t})
+}
+
+type closer interface {
+	Close() error
+}
+
+type writer[T any] struct {
+	f *os.File
+	w *parquetgo.GenericWriter[T]
+}
+
+func (w *writer[T]) Close() error {
+	if err := w.w.Close(); err != nil {
+		_ = w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// writerOf returns a function creating the writer of rows of T at a path.
+func writerOf[T any](m *Parquet) func(path string) error {
+	return func(path string) error {
+		return writeRows[T](m, path, nil)
+	}
+}
+
+// writeRows writes rows to the file at path, which is created on the first write of the load.
+func writeRows[T any](m *Parquet, path string, rows []T) error {
+	c, ok := m.writers[path]
+	if !ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil { //nolint:gosec
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		c = &writer[T]{f: f, w: parquetgo.NewGenericWriter[T](f, m.writerOptions...)}
+		m.writers[path] = c
+	}
+	w, ok := c.(*writer[T])
+	if !ok {
+		return fmt.Errorf("%s is written with another schema", path)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(rows)
+	return err
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	u := t.Time.UTC()
+	return &u
+}
+
+func nullFloat(f sql.NullFloat64) *float64 {
+	if !f.Valid {
+		return nil
+	}
+	return &f.Float64
+}
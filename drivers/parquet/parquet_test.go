@@ -0,0 +1,74 @@
+package parquet
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	parquetgo "github.com/parquet-go/parquet-go"
+)
+
+func TestSwapTables(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m, err := New(dir, drivers.DefaultTableNames(), drivers.Options{ParquetPartitionByPlatform: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unfinished load leaves no files
+	if err := m.CreateShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00001"), []byte(`{"Title":"dropped"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DropShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("got %v %v", entries, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte(`{"Title":"loaded","PublishedDate":"2023-01-02T03:04:05Z","CVSS":{"nvd":{"V3Vector":"CVSS:3.1/AV:N","V3Score":9.8}}}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, [][][]byte{
+			{[]byte("CVE-2023-00002"), []byte("debian"), []byte("12"), []byte("openssl"), []byte(`{"FixedVersion":"3.0.9-1","Severity":3}`)},
+			{[]byte("CVE-2023-00002"), []byte("npm::GitHub Security Advisory npm"), []byte(""), []byte("lodash"), []byte(`{"VulnerableVersions":["< 4.17.21"]}`)},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vulns, err := parquetgo.ReadFile[vulnerabilityRow](filepath.Join(dir, "vulnerabilities.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 1 || vulns[0].Title != "loaded" || vulns[0].PublishedDate.Format("2006-01-02") != "2023-01-02" || *vulns[0].CVSSV3Score != 9.8 {
+		t.Errorf("got %+v", vulns)
+	}
+	advs, err := parquetgo.ReadFile[advisoryRow](filepath.Join(dir, "vulnerability_advisories", "platform=npm::GitHub%20Security%20Advisory%20npm", "part-0.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advs) != 1 || advs[0].Package != "lodash" || len(advs[0].VulnerableVersions) != 1 {
+		t.Errorf("got %+v", advs)
+	}
+	// tables without rows have a file too
+	if _, err := os.Stat(filepath.Join(dir, "vulnerability_cwes.parquet")); err != nil {
+		t.Error(err)
+	}
+}
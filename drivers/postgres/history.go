@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+var (
+	vulnHistoryIndexes = []index{
+		{"vh_vulnerability_id_idx", "vulnerability_id, valid_to"},
+		{"vh_valid_idx", "valid_from, valid_to"},
+	}
+	advisoryHistoryIndexes = []index{
+		{"vah_vulnerability_advisories_idx", "vulnerability_id, platform, segment, package, valid_to"},
+		{"vah_source_package_idx", "platform, segment, package"},
+		{"vah_valid_idx", "valid_from, valid_to"},
+	}
+
+	vulnHistoryCopyColumns     = append(append([]string{}, vulnerabilityCopyColumns...), "valid_from")
+	advisoryHistoryCopyColumns = append(append([]string{}, advisoryCopyColumns...), "valid_from")
+)
+
+// createHistoryTables creates the history tables whose names are given.
+func (m *Postgres) createHistoryTables(ctx context.Context) error {
+	if m.vulnHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigserial PRIMARY KEY,
+vulnerability_id varchar (128) NOT NULL,
+value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+title text NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
+published_date timestamp,
+last_modified_date timestamp,
+cvss_v2_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v2_score double precision,
+cvss_v3_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v3_score double precision,
+valid_from timestamp NOT NULL,
+valid_to timestamp
+)`, m.vulnHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+
+		stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'history of vulnerabilities obtained via Trivy DB';", m.vulnHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		if err := m.createIndexes(ctx, m.vulnHistoryTableName, vulnHistoryIndexes, false); err != nil {
+			return err
+		}
+	}
+	if m.advisoryHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigserial PRIMARY KEY,
+vulnerability_id varchar (128) NOT NULL,
+platform varchar (50) NOT NULL,
+segment varchar (50) NOT NULL,
+package varchar (100) NOT NULL,
+value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+fixed_version varchar (255) NOT NULL DEFAULT '',
+affected_version varchar (255) NOT NULL DEFAULT '',
+vulnerable_versions json NOT NULL DEFAULT '[]',
+patched_versions json NOT NULL DEFAULT '[]',
+unaffected_versions json NOT NULL DEFAULT '[]',
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
+valid_from timestamp NOT NULL,
+valid_to timestamp
+)`, m.advisoryHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+
+		stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'history of vulnerability advisories obtained via Trivy DB';", m.advisoryHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		if err := m.createIndexes(ctx, m.advisoryHistoryTableName, advisoryHistoryIndexes, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Postgres) VulnHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s WHERE valid_to IS NULL;", m.vulnHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Postgres) VulnAdvisoryHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s WHERE valid_to IS NULL;", m.advisoryHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Postgres) InsertVulnHistory(ctx context.Context, validFrom time.Time, vulns [][][]byte) error {
+	rows, err := vulnerabilityRows(vulns)
+	if err != nil {
+		return err
+	}
+	return m.copyIn(ctx, m.vulnHistoryTableName, vulnHistoryCopyColumns, validFromRows(rows, validFrom))
+}
+
+func (m *Postgres) InsertVulnAdvisoryHistory(ctx context.Context, validFrom time.Time, secAdvisories [][][]byte) error {
+	rows, err := advisoryRows(secAdvisories)
+	if err != nil {
+		return err
+	}
+	return m.copyIn(ctx, m.advisoryHistoryTableName, advisoryHistoryCopyColumns, validFromRows(rows, validFrom))
+}
+
+// validFromRows appends validFrom to the rows.
+func validFromRows(rows [][]interface{}, validFrom time.Time) [][]interface{} {
+	for i := range rows {
+		rows[i] = append(rows[i], validFrom.UTC())
+	}
+	return rows
+}
+
+func (m *Postgres) CloseVulnHistory(ctx context.Context, validTo time.Time, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = $1 WHERE vulnerability_id = $2 AND valid_to IS NULL;", m.vulnHistoryTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{validTo.UTC(), vuln[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) CloseVulnAdvisoryHistory(ctx context.Context, validTo time.Time, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = $1 WHERE vulnerability_id = $2 AND platform = $3 AND segment = $4 AND package = $5 AND valid_to IS NULL;", m.advisoryHistoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{validTo.UTC(), secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
@@ -7,6 +7,72 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/lib/pq"
+)
+
+type index struct {
+	name    string
+	columns string
+}
+
+var (
+	vulnerabilitiesIndexes = []index{
+		{"v_vulnerability_id_idx", "vulnerability_id"},
+		{"v_severity_idx", "severity"},
+		{"v_published_date_idx", "published_date"},
+		{"v_last_modified_date_idx", "last_modified_date"},
+	}
+	advisoryIndexes = []index{
+		{"va_vulnerability_advisories_idx", "vulnerability_id, platform, segment, package"},
+		{"va_vulnerability_id_idx", "vulnerability_id"},
+		{"va_platform_idx", "platform"},
+		{"va_source_idx", "platform, segment"},
+		{"va_source_package_idx", "platform, segment, package"},
+		{"va_status_idx", "status"},
+	}
+	dataSourceIndexes = []index{
+		{"v_source_key_idx", "source_key"},
+	}
+	cvssIndexes = []index{
+		{"vc_vulnerability_id_idx", "vulnerability_id"},
+		{"vc_source_idx", "source"},
+	}
+	referencesIndexes = []index{
+		{"vr_vulnerability_id_idx", "vulnerability_id"},
+	}
+	cwesIndexes = []index{
+		{"vcw_vulnerability_id_idx", "vulnerability_id"},
+		{"vcw_cwe_id_idx", "cwe_id"},
+	}
+	javaArtifactsIndexes = []index{
+		{"ja_group_id_artifact_id_idx", "group_id, artifact_id"},
+	}
+	javaIndicesIndexes = []index{
+		{"ji_artifact_id_idx", "artifact_id"},
+		{"ji_sha1_idx", "sha1"},
+	}
+	changesIndexes = []index{
+		{"vch_run_id_idx", "run_id"},
+		{"vch_vulnerability_id_idx", "vulnerability_id"},
+		{"vch_source_package_idx", "platform, segment, package"},
+		{"vch_recorded_at_idx", "recorded_at"},
+	}
+)
+
+const (
+	vulnerabilityColumns = "title,severity,published_date,last_modified_date,cvss_v2_vector,cvss_v2_score,cvss_v3_vector,cvss_v3_score"
+	advisoryColumns      = "fixed_version,affected_version,vulnerable_versions,patched_versions,unaffected_versions,status,severity"
+	cvssColumns          = "v2_vector,v2_score,v3_vector,v3_score,v4_vector,v4_score"
+)
+
+var (
+	vulnerabilityCopyColumns = append([]string{"vulnerability_id", "value", "value_hash"}, strings.Split(vulnerabilityColumns, ",")...)
+	advisoryCopyColumns      = append([]string{"vulnerability_id", "platform", "segment", "package", "value", "value_hash"}, strings.Split(advisoryColumns, ",")...)
+	cvssCopyColumns          = append([]string{"vulnerability_id", "source"}, strings.Split(cvssColumns, ",")...)
+	changeCopyColumns        = []string{"run_id", "recorded_at", "change_type", "vulnerability_id", "platform", "segment", "package", "fixed_version", "status", "severity"}
 )
 
 type Postgres struct {
@@ -14,19 +80,52 @@ type Postgres struct {
 	vulnerabilitiesTableName string
 	advisoryTableName        string
 	dataSourceTableName      string
+	metadataTableName        string
+	changesTableName         string
+	vulnHistoryTableName     string
+	advisoryHistoryTableName string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	shadow                   bool
+	// tx is the transaction that the shadow tables are loaded and swapped in
+	tx *sql.Tx
 }
 
 // New return *Postgres
-func New(db *sql.DB, vulnerabilitiesTableName, advisoryTableName string, dataSourceTableName string) (*Postgres, error) {
+func New(db *sql.DB, tableNames drivers.TableNames) (*Postgres, error) {
 	return &Postgres{
 		db:                       db,
-		vulnerabilitiesTableName: vulnerabilitiesTableName,
-		advisoryTableName:        advisoryTableName,
-		dataSourceTableName:      dataSourceTableName,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		changesTableName:         tableNames.Changes,
+		vulnHistoryTableName:     tableNames.VulnHistory,
+		advisoryHistoryTableName: tableNames.AdvisoryHistory,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
 	}, nil
 }
 
 func (m *Postgres) Migrate(ctx context.Context) error {
+	if err := m.createMetadataTable(ctx); err != nil {
+		return err
+	}
+	if m.changesTableName != "" {
+		if err := m.createChangesTable(ctx); err != nil {
+			return err
+		}
+	}
+	if err := m.createHistoryTables(ctx); err != nil {
+		return err
+	}
+
 	var count int
 	stmt := fmt.Sprintf("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name IN ('%s', '%s','%s');", m.vulnerabilitiesTableName, m.advisoryTableName, m.dataSourceTableName) //nolint:gosec
 	if err := m.db.QueryRowContext(ctx, stmt).Scan(&count); err != nil {
@@ -47,198 +146,839 @@ func (m *Postgres) Migrate(ctx context.Context) error {
 		if _, err := m.db.Exec(stmt); err != nil {
 			return err
 		}
-		return nil
+		return m.addColumns(ctx)
 	case 1:
 		return errors.New("invalid table schema")
 	}
 
-	stmt = fmt.Sprintf(`CREATE TABLE %s (
+	if err := m.createVulnerabilitiesTable(ctx, m.vulnerabilitiesTableName); err != nil {
+		return err
+	}
+	if err := m.createIndexes(ctx, m.vulnerabilitiesTableName, vulnerabilitiesIndexes, false); err != nil {
+		return err
+	}
+
+	if err := m.createAdvisoryTable(ctx, m.advisoryTableName); err != nil {
+		return err
+	}
+	if err := m.createIndexes(ctx, m.advisoryTableName, advisoryIndexes, false); err != nil {
+		return err
+	}
+
+	// 创建 data_source 表
+	if err := m.createDataSourceTable(ctx, m.dataSourceTableName); err != nil {
+		return err
+	}
+	if err := m.createIndexes(ctx, m.dataSourceTableName, dataSourceIndexes, false); err != nil {
+		return err
+	}
+
+	return m.createTables(ctx, m.vulnChildTables())
+}
+
+func (m *Postgres) MigrateJavaDB(ctx context.Context) error {
+	return m.createTables(ctx, m.javaDBTables())
+}
+
+func (m *Postgres) createTables(ctx context.Context, tables []table) error {
+	for _, t := range tables {
+		if err := t.create(ctx, t.name); err != nil {
+			return err
+		}
+		if err := m.createIndexes(ctx, t.name, t.indexes, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumns adds the columns and indexes added after v2 to the existing tables.
+func (m *Postgres) addColumns(ctx context.Context) error {
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{m.vulnerabilitiesTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.dataSourceTableName, "value_hash", "char (64) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "title", "text NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "severity", "varchar (16) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "published_date", "timestamp"},
+		{m.vulnerabilitiesTableName, "last_modified_date", "timestamp"},
+		{m.vulnerabilitiesTableName, "cvss_v2_vector", "varchar (128) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v2_score", "double precision"},
+		{m.vulnerabilitiesTableName, "cvss_v3_vector", "varchar (128) NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v3_score", "double precision"},
+		{m.advisoryTableName, "fixed_version", "varchar (255) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "affected_version", "varchar (255) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "vulnerable_versions", "json NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "patched_versions", "json NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "unaffected_versions", "json NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "status", "varchar (32) NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "severity", "varchar (16) NOT NULL DEFAULT ''"},
+	}
+	changed := map[string]bool{}
+	for _, c := range columns {
+		added, err := m.addColumnIfNotExists(ctx, c.table, c.name, c.definition)
+		if err != nil {
+			return err
+		}
+		changed[c.table] = changed[c.table] || added
+	}
+	for _, t := range m.vulnChildTables() {
+		exists, err := m.tableExists(ctx, t.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := t.create(ctx, t.name); err != nil {
+			return err
+		}
+		// rows of the new table are derived from vulnerabilities
+		changed[m.vulnerabilitiesTableName] = true
+	}
+	if err := m.resetHashes(ctx, changed); err != nil {
+		return err
+	}
+	for _, t := range m.tables() {
+		if err := m.createIndexes(ctx, t.name, t.indexes, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Postgres) addColumnIfNotExists(ctx context.Context, table, column, definition string) (bool, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2;"
+	if err := m.db.QueryRowContext(ctx, stmt, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Postgres) tableExists(ctx context.Context, table string) (bool, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1;"
+	if err := m.db.QueryRowContext(ctx, stmt, table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// resetHashes clears value_hash of the tables with added columns,
+// so that the next incremental load fills the new columns of all rows.
+func (m *Postgres) resetHashes(ctx context.Context, tables map[string]bool) error {
+	for table, changed := range tables {
+		if !changed {
+			continue
+		}
+		stmt := fmt.Sprintf("UPDATE %s SET value_hash = '';", table)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Postgres) createVulnerabilitiesTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE %s (
 id serial PRIMARY KEY,
 vulnerability_id varchar (128) NOT NULL,
 value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+title text NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
+published_date timestamp,
+last_modified_date timestamp,
+cvss_v2_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v2_score double precision,
+cvss_v3_vector varchar (128) NOT NULL DEFAULT '',
+cvss_v3_score double precision,
 created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
-)`, m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'vulnerability obtained via Trivy DB';", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
-		return err
-	}
-
-	stmt = fmt.Sprintf("CREATE INDEX v_vulnerability_id_idx ON %s(vulnerability_id);", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'vulnerability obtained via Trivy DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	stmt = fmt.Sprintf(`CREATE TABLE %s (
+func (m *Postgres) createAdvisoryTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE %s (
 id serial PRIMARY KEY,
 vulnerability_id varchar (128) NOT NULL,
 platform varchar (50) NOT NULL,
 segment varchar (50) NOT NULL,
 package varchar (100) NOT NULL,
 value json NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+fixed_version varchar (255) NOT NULL DEFAULT '',
+affected_version varchar (255) NOT NULL DEFAULT '',
+vulnerable_versions json NOT NULL DEFAULT '[]',
+patched_versions json NOT NULL DEFAULT '[]',
+unaffected_versions json NOT NULL DEFAULT '[]',
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT '',
 created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
-)`, m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf(`COMMENT ON TABLE %s IS 'vulnerability advisories obtained via Trivy DB';`, m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	stmt = fmt.Sprintf(`COMMENT ON TABLE %s IS 'vulnerability advisories obtained via Trivy DB';`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	stmt = fmt.Sprintf("CREATE INDEX va_vulnerability_advisories_idx ON %s(vulnerability_id, platform, segment, package)", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Postgres) createDataSourceTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE %s (
+id serial PRIMARY KEY,
+source_key varchar (128) NOT NULL,
+source_id varchar (128) NOT NULL,
+source_name varchar (128) NOT NULL,
+source_url varchar (128) NOT NULL,
+value_hash char (64) NOT NULL DEFAULT '',
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX va_vulnerability_id_idx ON %s(vulnerability_id)", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'Data Source obtained via Trivy DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	stmt = fmt.Sprintf("CREATE INDEX va_platform_idx ON %s(platform)", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Postgres) createCVSSTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id serial PRIMARY KEY,
+vulnerability_id varchar (128) NOT NULL,
+source varchar (64) NOT NULL,
+v2_vector varchar (128) NOT NULL DEFAULT '',
+v2_score double precision,
+v3_vector varchar (128) NOT NULL DEFAULT '',
+v3_score double precision,
+v4_vector varchar (255) NOT NULL DEFAULT '',
+v4_score double precision,
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX va_source_idx ON %s(platform, segment)", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'CVSS per vulnerability and source obtained via Trivy DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	stmt = fmt.Sprintf("CREATE INDEX va_source_package_idx ON %s(platform, segment, package)", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Postgres) createReferencesTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id serial PRIMARY KEY,
+vulnerability_id varchar (128) NOT NULL,
+url text NOT NULL,
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	// 创建 data_source 表
-	stmt = fmt.Sprintf(`CREATE TABLE %s (
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'vulnerability references obtained via Trivy DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Postgres) createCWEsTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 id serial PRIMARY KEY,
-source_key varchar (128) NOT NULL,
-source_id varchar (128) NOT NULL,
-source_name varchar (128) NOT NULL,
-source_url varchar (128) NOT NULL,
+vulnerability_id varchar (128) NOT NULL,
+cwe_id varchar (32) NOT NULL,
 created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
-)`, m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'Data Source obtained via Trivy DB';", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'vulnerability CWE IDs obtained via Trivy DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	stmt = fmt.Sprintf("CREATE INDEX v_source_key_idx ON %s(source_key);", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Postgres) createJavaArtifactsTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id serial PRIMARY KEY,
+group_id varchar (255) NOT NULL,
+artifact_id varchar (255) NOT NULL,
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'Maven artifacts obtained via Trivy Java DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
 	return nil
 }
 
-func (m *Postgres) InsertVuln(ctx context.Context, vulns [][][]byte) error {
-	var iv []string
-	for i := 0; i < len(vulns); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
+func (m *Postgres) createJavaIndicesTable(ctx context.Context, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id serial PRIMARY KEY,
+artifact_id integer NOT NULL,
+version text NOT NULL,
+sha1 char (40) NOT NULL,
+archive_type varchar (16) NOT NULL,
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
 	}
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,value) VALUES %s", m.vulnerabilitiesTableName, strings.Join(iv, ",")) //nolint:gosec
 
-	ins, err := m.db.Prepare(query)
-	if err != nil {
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'SHA1 digests of Maven artifact versions obtained via Trivy Java DB';", table)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
+}
 
-	var values []interface{}
-	for _, vuln := range vulns {
-		values = append(values, vuln[0], vuln[1])
+// createIndexes creates indexes on table. Index names are unique per schema in PostgreSQL,
+// so indexes of shadow tables are created with shadow names and renamed when swapping.
+func (m *Postgres) createIndexes(ctx context.Context, table string, indexes []index, shadow bool) error {
+	for _, idx := range indexes {
+		name := idx.name
+		if shadow {
+			name = drivers.ShadowName(idx.name)
+		}
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s);", name, table, idx.columns)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
 	}
-	{
-		_, err := ins.Exec(values...)
+	return nil
+}
+
+func (m *Postgres) createMetadataTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id serial PRIMARY KEY,
+trivy_db_version integer NOT NULL,
+updated_at timestamp,
+next_update timestamp,
+downloaded_at timestamp,
+trivy_db_to_version varchar (32) NOT NULL,
+row_counts json NOT NULL,
+created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, m.metadataTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'Trivy DB metadata recorded per run';", m.metadataTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return nil
 }
 
-func (m *Postgres) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
-	var iv []string
-	for i := 0; i < len(secAdvisories); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5))
+func (m *Postgres) createChangesTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+id bigserial PRIMARY KEY,
+run_id char (36) NOT NULL,
+recorded_at timestamp NOT NULL,
+change_type varchar (16) NOT NULL,
+vulnerability_id varchar (128) NOT NULL,
+platform varchar (50) NOT NULL,
+segment varchar (50) NOT NULL,
+package varchar (100) NOT NULL,
+fixed_version varchar (255) NOT NULL DEFAULT '',
+status varchar (32) NOT NULL DEFAULT '',
+severity varchar (16) NOT NULL DEFAULT ''
+)`, m.changesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
 	}
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,platform,segment,package,value) VALUES %s", m.advisoryTableName, strings.Join(iv, ",")) //nolint:gosec
-	ins, err := m.db.Prepare(query)
+
+	stmt = fmt.Sprintf("COMMENT ON TABLE %s IS 'vulnerability advisories added, removed or modified by each run';", m.changesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return m.createIndexes(ctx, m.changesTableName, changesIndexes, false)
+}
+
+func (m *Postgres) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	rows, err := vulnerabilityRows(vulns)
 	if err != nil {
 		return err
 	}
+	return m.copyIn(ctx, m.vulnerabilitiesTableName, vulnerabilityCopyColumns, rows)
+}
 
-	var values []interface{}
-	for _, secAdvisory := range secAdvisories {
-		values = append(values, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3], secAdvisory[4])
+// vulnerabilityRows returns the rows of vulnerabilityCopyColumns.
+func vulnerabilityRows(vulns [][][]byte) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, append([]interface{}{string(vuln[0]), string(vuln[1]), drivers.Hash(vuln[1])}, vulnerabilityValues(v)...))
 	}
-	{
-		_, err := ins.Exec(values...)
+	return rows, nil
+}
+
+func (m *Postgres) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	rows, err := advisoryRows(secAdvisories)
+	if err != nil {
 		return err
 	}
+	return m.copyIn(ctx, m.advisoryTableName, advisoryCopyColumns, rows)
 }
 
-func (m *Postgres) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
-	// 定义一个结构体用于反序列化 JSON
-	type Item struct {
-		ID   string `json:"ID"`
-		Name string `json:"Name"`
-		URL  string `json:"URL"`
+// advisoryRows returns the rows of advisoryCopyColumns.
+func advisoryRows(secAdvisories [][][]byte) ([][]interface{}, error) {
+	var rows [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, append([]interface{}{string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]),
+			string(secAdvisory[4]), drivers.Hash(secAdvisory[4])}, advisoryValues(a)...))
 	}
+	return rows, nil
+}
 
-	var iv []string
-	for i := 0; i < len(dataSources); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
+func (m *Postgres) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var rows [][]interface{}
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, append([]interface{}{string(c[0]), string(c[1])}, cvssValues(v)...))
 	}
-	query := fmt.Sprintf("INSERT INTO %s(source_key,source_id,source_name,source_url) VALUES %s", m.dataSourceTableName, strings.Join(iv, ",")) //nolint:gosec
+	return m.copyIn(ctx, m.cvssTableName, cvssCopyColumns, rows)
+}
 
-	ins, err := m.db.Prepare(query)
-	if err != nil {
-		return err
+func (m *Postgres) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.referencesTableName, "url", references)
+}
+
+func (m *Postgres) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.cwesTableName, "cwe_id", cwes)
+}
+
+// insertVulnChildRows inserts rows of [vulnerability_id, column] into table.
+func (m *Postgres) insertVulnChildRows(ctx context.Context, table, column string, rows [][][]byte) error {
+	return m.insertRows(ctx, table, []string{"vulnerability_id", column}, rows)
+}
+
+func (m *Postgres) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	return m.insertRows(ctx, m.javaArtifactsTableName, []string{"id", "group_id", "artifact_id"}, artifacts)
+}
+
+func (m *Postgres) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	return m.insertRows(ctx, m.javaIndicesTableName, []string{"artifact_id", "version", "sha1", "archive_type"}, indices)
+}
+
+// insertRows inserts rows holding the text values of columns as they are into table.
+func (m *Postgres) insertRows(ctx context.Context, table string, columns []string, rows [][][]byte) error {
+	var values [][]interface{}
+	for _, r := range rows {
+		v := make([]interface{}, len(r))
+		for i, c := range r {
+			v[i] = string(c)
+		}
+		values = append(values, v)
 	}
+	return m.copyIn(ctx, table, columns, values)
+}
 
-	var values []interface{}
+func (m *Postgres) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	var rows [][]interface{}
 	for _, dataSource := range dataSources {
 		// 反序列化 JSON 到结构体
-		var item Item
-		if err = json.Unmarshal(dataSource[1], &item); err != nil {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		rows = append(rows, []interface{}{string(dataSource[0]), item.ID, item.Name, item.URL, drivers.Hash(dataSource[1])})
+	}
+	return m.copyIn(ctx, m.dataSourceTableName, []string{"source_key", "source_id", "source_name", "source_url", "value_hash"}, rows)
+}
+
+// copyIn writes rows into table with the COPY protocol. While loading shadow tables, rows are written in the
+// transaction of the load, which is committed by the swap. Otherwise they are written in a transaction of their own.
+// []byte values are encoded as bytea by COPY, so text values must be passed as string.
+func (m *Postgres) copyIn(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	return m.copyInto(ctx, m.tableName(table), columns, rows)
+}
+
+// copyInto is copyIn writing into table as it is named.
+func (m *Postgres) copyInto(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	tx := m.tx
+	if tx == nil {
+		var err error
+		tx, err = m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck
+	}
+	stmt, err := tx.PrepareContext(ctx, copyInStmt(table, columns))
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := stmt.ExecContext(ctx, r...); err != nil {
+			_ = stmt.Close()
 			return err
 		}
-		values = append(values, dataSource[0], item.ID, item.Name, item.URL)
 	}
-	{
-		_, err = ins.Exec(values...)
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
 		return err
 	}
+	if m.tx == nil {
+		return tx.Commit()
+	}
+	return nil
 }
 
-func (m *Postgres) TruncateVulns(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+// copyInStmt returns the COPY statement of table, which may be qualified by a schema.
+func copyInStmt(table string, columns []string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return pq.CopyInSchema(schema, name, columns...)
+	}
+	return pq.CopyIn(table, columns...)
+}
+
+func (m *Postgres) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
+	if err != nil {
+		return err
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s(trivy_db_version,updated_at,next_update,downloaded_at,trivy_db_to_version,row_counts) VALUES ($1,$2,$3,$4,$5,$6);", m.metadataTableName) //nolint:gosec
+	if _, err := m.db.ExecContext(ctx, stmt, metadata.Version, drivers.NullTime(metadata.UpdatedAt), drivers.NullTime(metadata.NextUpdate),
+		drivers.NullTime(metadata.DownloadedAt), metadata.TrivyDBToVersion, string(counts)); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Postgres) TruncateVulnAdvisories(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Postgres) InsertVulnAdvisoryChanges(ctx context.Context, runID string, recordedAt time.Time, changes [][][]byte) error {
+	var rows [][]interface{}
+	for _, c := range changes {
+		a, err := drivers.ParseAdvisoryChange(c)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []interface{}{runID, recordedAt.UTC(), string(c[0]), string(c[1]), string(c[2]), string(c[3]), string(c[4]), a.FixedVersion, a.Status, a.Severity})
+	}
+	// the changes table has no shadow, so the changes of a swap load are committed with the swap
+	return m.copyInto(ctx, m.changesTableName, changeCopyColumns, rows)
+}
+
+func (m *Postgres) VulnHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s;", m.vulnerabilitiesTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Postgres) VulnAdvisoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s;", m.advisoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Postgres) DataSourceHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT source_key, value_hash FROM %s;", m.dataSourceTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Postgres) UpdateVuln(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = $1, value_hash = $2, title = $3, severity = $4, published_date = $5, last_modified_date = $6, "+
+		"cvss_v2_vector = $7, cvss_v2_score = $8, cvss_v3_vector = $9, cvss_v3_score = $10 WHERE vulnerability_id = $11;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{vuln[1], drivers.Hash(vuln[1])}, vulnerabilityValues(v)...)
+		args = append(args, append(a, vuln[0]))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) UpdateVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = $1, value_hash = $2, fixed_version = $3, affected_version = $4, vulnerable_versions = $5, patched_versions = $6, "+
+		"unaffected_versions = $7, status = $8, severity = $9 WHERE vulnerability_id = $10 AND platform = $11 AND segment = $12 AND package = $13;", m.advisoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		adv, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{secAdvisory[4], drivers.Hash(secAdvisory[4])}, advisoryValues(adv)...)
+		args = append(args, append(a, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) UpdateDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET source_id = $1, source_name = $2, source_url = $3, value_hash = $4 WHERE source_key = $5;", m.dataSourceTableName)
+	var args [][]interface{}
+	for _, dataSource := range dataSources {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		args = append(args, []interface{}{item.ID, item.Name, item.URL, drivers.Hash(dataSource[1]), dataSource[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) DeleteVulns(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{vuln[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) DeleteVulnAdvisories(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1 AND platform = $2 AND segment = $3 AND package = $4;", m.advisoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) DeleteDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE source_key = $1;", m.dataSourceTableName)
+	var args [][]interface{}
+	for _, dataSource := range dataSources {
+		args = append(args, []interface{}{dataSource[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) DeleteVulnCVSS(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cvssTableName, vulns)
+}
+
+func (m *Postgres) DeleteVulnReferences(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.referencesTableName, vulns)
+}
+
+func (m *Postgres) DeleteVulnCWEs(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cwesTableName, vulns)
+}
+
+// deleteVulnChildRows deletes the rows of vulnerabilities from table.
+func (m *Postgres) deleteVulnChildRows(ctx context.Context, table string, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1;", table)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{vuln[0]})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Postgres) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.tables())
+}
+
+// SwapTables drops the tables and renames the shadow tables, their indexes and sequences in one transaction.
+func (m *Postgres) SwapTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.tables())
+}
+
+func (m *Postgres) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.tables())
+}
+
+func (m *Postgres) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Postgres) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.javaDBTables())
+}
+
+func (m *Postgres) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Postgres) createShadowTables(ctx context.Context, tables []table) error {
+	if err := m.dropShadowTables(ctx, tables); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		shadow := drivers.ShadowName(t.name)
+		if err := t.create(ctx, shadow); err != nil {
+			return err
+		}
+		if err := m.createIndexes(ctx, shadow, t.indexes, true); err != nil {
+			return err
+		}
+	}
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
+	m.tx = tx
+	m.shadow = true
 	return nil
 }
 
-// TruncateDataSource 清空数据源表的数据（使用 TRUNCATE 语句）。
-func (m *Postgres) TruncateDataSource(ctx context.Context) error {
-	stmt := fmt.Sprintf("TRUNCATE TABLE %s;", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+// swapTables swaps the tables in the transaction of the load, so the loaded rows are committed with the swap.
+func (m *Postgres) swapTables(ctx context.Context, tables []table) error {
+	tx := m.tx
+	m.tx = nil
+	if tx == nil {
+		var err error
+		tx, err = m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, t := range tables {
+		shadow := drivers.ShadowName(t.name)
+		stmts := []string{
+			fmt.Sprintf("DROP TABLE %s;", t.name),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", shadow, t.name),
+			fmt.Sprintf("ALTER INDEX %s_pkey RENAME TO %s_pkey;", shadow, t.name),
+			fmt.Sprintf("ALTER SEQUENCE %s_id_seq RENAME TO %s_id_seq;", shadow, t.name),
+		}
+		for _, idx := range t.indexes {
+			stmts = append(stmts, fmt.Sprintf("ALTER INDEX %s RENAME TO %s;", drivers.ShadowName(idx.name), idx.name))
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return err
 	}
+	m.shadow = false
 	return nil
 }
+
+func (m *Postgres) dropShadowTables(ctx context.Context, tables []table) error {
+	if m.tx != nil {
+		// the transaction of an unfinished load locks the shadow tables
+		if err := m.tx.Rollback(); err != nil {
+			return err
+		}
+		m.tx = nil
+	}
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.ShadowName(t.name))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	m.shadow = false
+	return nil
+}
+
+// vulnerabilityValues returns the values of vulnerabilityColumns.
+func vulnerabilityValues(v drivers.Vulnerability) []interface{} {
+	return []interface{}{v.Title, v.Severity, v.PublishedDate, v.LastModifiedDate, v.CVSSV2Vector, v.CVSSV2Score, v.CVSSV3Vector, v.CVSSV3Score}
+}
+
+// advisoryValues returns the values of advisoryColumns.
+func advisoryValues(a drivers.Advisory) []interface{} {
+	return []interface{}{a.FixedVersion, a.AffectedVersion, drivers.JSONArray(a.VulnerableVersions), drivers.JSONArray(a.PatchedVersions),
+		drivers.JSONArray(a.UnaffectedVersions), a.Status, a.Severity}
+}
+
+// cvssValues returns the values of cvssColumns.
+func cvssValues(c drivers.CVSS) []interface{} {
+	return []interface{}{c.V2Vector, c.V2Score, c.V3Vector, c.V3Score, c.V4Vector, c.V4Score}
+}
+
+type table struct {
+	name    string
+	create  func(ctx context.Context, table string) error
+	indexes []index
+}
+
+func (m *Postgres) tables() []table {
+	return append([]table{
+		{m.vulnerabilitiesTableName, m.createVulnerabilitiesTable, vulnerabilitiesIndexes},
+		{m.advisoryTableName, m.createAdvisoryTable, advisoryIndexes},
+		{m.dataSourceTableName, m.createDataSourceTable, dataSourceIndexes},
+	}, m.vulnChildTables()...)
+}
+
+// vulnChildTables returns the tables whose rows are derived from vulnerabilities.
+func (m *Postgres) vulnChildTables() []table {
+	return []table{
+		{m.cvssTableName, m.createCVSSTable, cvssIndexes},
+		{m.referencesTableName, m.createReferencesTable, referencesIndexes},
+		{m.cwesTableName, m.createCWEsTable, cwesIndexes},
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Postgres) javaDBTables() []table {
+	return []table{
+		{m.javaArtifactsTableName, m.createJavaArtifactsTable, javaArtifactsIndexes},
+		{m.javaIndicesTableName, m.createJavaIndicesTable, javaIndicesIndexes},
+	}
+}
+
+func (m *Postgres) tableName(name string) string {
+	if m.shadow {
+		return drivers.ShadowName(name)
+	}
+	return name
+}
@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	_ "github.com/lib/pq"
+	"github.com/xo/dburl"
+)
+
+const testSchema = "trivy_db_to_test"
+
+// testDB returns a connection to an empty schema of $TEST_POSTGRES_DSN.
+func testDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	u, err := dburl.Parse(dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := sql.Open(u.Driver, u.DSN)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE;", testSchema),
+		fmt.Sprintf("CREATE SCHEMA %s;", testSchema),
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	sdb, err := sql.Open(u.Driver, u.DSN+" search_path="+testSchema)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = sdb.Close()
+	})
+	return sdb
+}
+
+func testAdvisories(n int) [][][]byte {
+	var rows [][][]byte
+	for i := 0; i < n; i++ {
+		rows = append(rows, [][]byte{
+			[]byte(fmt.Sprintf("CVE-2023-%05d", i)), []byte("debian"), []byte("12"), []byte("openssl"),
+			[]byte(`{"FixedVersion":"3.0.9-1","VulnerableVersions":["< 3.0.9"],"Severity":3}`),
+		})
+	}
+	return rows
+}
+
+func TestSwapTables(t *testing.T) {
+	ctx := context.Background()
+	db := testDB(t)
+	m, err := New(db, drivers.DefaultTableNames())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00001"), []byte(`{"Title":"tab\tand \\ backslash","CVSS":{"nvd":{"V3Vector":"CVSS:3.1/AV:N","V3Score":9.8}}}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, testAdvisories(3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertDataSource(ctx, [][][]byte{{[]byte("debian 12"), []byte(`{"ID":"debian","Name":"Debian Security Tracker","URL":"https://salsa.debian.org/security-tracker-team/security-tracker"}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var title string
+	var score float64
+	if err := db.QueryRow("SELECT title, cvss_v3_score FROM vulnerabilities WHERE vulnerability_id = 'CVE-2023-00001'").Scan(&title, &score); err != nil {
+		t.Fatal(err)
+	}
+	if title != "tab\tand \\ backslash" || score != 9.8 {
+		t.Errorf("got %q %v", title, score)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM vulnerability_advisories WHERE severity = 'HIGH'").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d advisories, want 3", count)
+	}
+}
+
+// BenchmarkInsertVulnAdvisory compares COPY with the multi-row INSERT that was used before.
+func BenchmarkInsertVulnAdvisory(b *testing.B) {
+	ctx := context.Background()
+	db := testDB(b)
+	m, err := New(db, drivers.DefaultTableNames())
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		b.Fatal(err)
+	}
+	const chunkSize = 5000
+	rows := testAdvisories(chunkSize)
+
+	b.Run("copy", func(b *testing.B) {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := m.InsertVulnAdvisory(ctx, rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(b.N*chunkSize)/b.Elapsed().Seconds(), "rows/s")
+		if err := m.DropShadowTables(ctx); err != nil {
+			b.Fatal(err)
+		}
+	})
+
+	b.Run("insert", func(b *testing.B) {
+		if err := m.CreateShadowTables(ctx); err != nil {
+			b.Fatal(err)
+		}
+		// the rows are inserted outside the transaction of the load as before
+		tx := m.tx
+		m.tx = nil
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := insertValues(ctx, m, rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(b.N*chunkSize)/b.Elapsed().Seconds(), "rows/s")
+		m.tx = tx
+		if err := m.DropShadowTables(ctx); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+// insertValues inserts advisories with a multi-row INSERT per chunk.
+func insertValues(ctx context.Context, m *Postgres, secAdvisories [][][]byte) error {
+	const maxPlaceholders = 65535
+	for _, c := range drivers.Chunk(secAdvisories, len(advisoryCopyColumns), maxPlaceholders) {
+		var iv []string
+		var values []interface{}
+		for i, secAdvisory := range c {
+			var p []string
+			for j := 1; j <= len(advisoryCopyColumns); j++ {
+				p = append(p, fmt.Sprintf("$%d", i*len(advisoryCopyColumns)+j))
+			}
+			iv = append(iv, "("+strings.Join(p, ", ")+")")
+			a, err := drivers.ParseAdvisory(secAdvisory[4])
+			if err != nil {
+				return err
+			}
+			values = append(values, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3], secAdvisory[4], drivers.Hash(secAdvisory[4]))
+			values = append(values, advisoryValues(a)...)
+		}
+		query := fmt.Sprintf("INSERT INTO %s(%s) VALUES %s", m.tableName(m.advisoryTableName), strings.Join(advisoryCopyColumns, ","), strings.Join(iv, ",")) //nolint:gosec
+		if _, err := m.db.ExecContext(ctx, query, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
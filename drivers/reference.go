@@ -0,0 +1,41 @@
+package drivers
+
+import (
+	"encoding/json"
+)
+
+// ReferenceRows returns rows of [vulnerability_id, url] for the References of each row of
+// [vulnerability_id, value] in the vulnerability bucket. Duplicate URLs are written once.
+func ReferenceRows(vulns [][][]byte) ([][][]byte, error) {
+	return explode(vulns, func(v vulnLists) []string { return v.References })
+}
+
+// CWERows returns rows of [vulnerability_id, cwe_id] for the CweIDs of each row of
+// [vulnerability_id, value] in the vulnerability bucket. Duplicate IDs are written once.
+func CWERows(vulns [][][]byte) ([][][]byte, error) {
+	return explode(vulns, func(v vulnLists) []string { return v.CweIDs })
+}
+
+type vulnLists struct {
+	References []string
+	CweIDs     []string
+}
+
+func explode(vulns [][][]byte, list func(v vulnLists) []string) ([][][]byte, error) {
+	var rows [][][]byte
+	for _, vuln := range vulns {
+		var v vulnLists
+		if err := json.Unmarshal(vuln[1], &v); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, s := range list(v) {
+			if s == "" || seen[s] {
+				continue
+			}
+			seen[s] = true
+			rows = append(rows, [][]byte{vuln[0], []byte(s)})
+		}
+	}
+	return rows, nil
+}
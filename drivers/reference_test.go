@@ -0,0 +1,32 @@
+package drivers
+
+import "testing"
+
+func TestReferenceRowsAndCWERows(t *testing.T) {
+	vulns := [][][]byte{
+		{[]byte("CVE-2023-0001"), []byte(`{"References":["https://example.com/1","https://example.com/2","https://example.com/1"],"CweIDs":["CWE-79"]}`)},
+		{[]byte("CVE-2023-0002"), []byte(`{"CweIDs":["NVD-CWE-Other","CWE-89"]}`)},
+	}
+	for _, tt := range []struct {
+		name string
+		fn   func([][][]byte) ([][][]byte, error)
+		want []string
+	}{
+		{"ReferenceRows", ReferenceRows, []string{"CVE-2023-0001 https://example.com/1", "CVE-2023-0001 https://example.com/2"}},
+		{"CWERows", CWERows, []string{"CVE-2023-0001 CWE-79", "CVE-2023-0002 NVD-CWE-Other", "CVE-2023-0002 CWE-89"}},
+	} {
+		rows, err := tt.fn(vulns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != len(tt.want) {
+			t.Errorf("%s got %d rows, want %d", tt.name, len(rows), len(tt.want))
+			continue
+		}
+		for i, r := range rows {
+			if got := string(r[0]) + " " + string(r[1]); got != tt.want[i] {
+				t.Errorf("%s row %d = %s, want %s", tt.name, i, got, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compactAdvisoryIndexes replace advisoryIndexes in a compact database.
+// Lookups by package are answered from va_source_package_idx alone.
+var compactAdvisoryIndexes = []index{
+	{"va_vulnerability_advisories_idx", "vulnerability_id, platform, segment, package"},
+	{"va_source_package_idx", "platform, segment, package, vulnerability_id, fixed_version, status, severity"},
+}
+
+var valueEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+
+// compressValue returns value compressed as a zstd frame.
+func compressValue(value []byte) []byte {
+	return valueEncoder.EncodeAll(value, nil)
+}
+
+// createAdvisoryValuesTable creates the table holding the values of advisories of a compact database once per value_hash.
+func (m *Sqlite) createAdvisoryValuesTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        value_hash TEXT PRIMARY KEY,
+        value BLOB NOT NULL
+    ) WITHOUT ROWID;`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertAdvisoryValues inserts the values of secAdvisories that are not stored yet.
+func (m *Sqlite) insertAdvisoryValues(ctx context.Context, secAdvisories [][][]byte) error {
+	var iv []string
+	var values []interface{}
+	seen := map[string]struct{}{}
+	for _, secAdvisory := range secAdvisories {
+		h := drivers.Hash(secAdvisory[4])
+		if _, ok := seen[h]; ok {
+			continue
+		}
+		seen[h] = struct{}{}
+		iv = append(iv, placeholders(len(values), 2))
+		values = append(values, h, compressValue(secAdvisory[4]))
+	}
+	query := fmt.Sprintf("INSERT OR IGNORE INTO %s(value_hash,value) VALUES %s", m.tableName(m.advisoryValuesTableName), strings.Join(iv, ",")) //nolint:gosec
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
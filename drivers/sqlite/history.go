@@ -0,0 +1,176 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+var (
+	vulnHistoryIndexes = []index{
+		{"vh_vulnerability_id_idx", "vulnerability_id, valid_to"},
+		{"vh_valid_idx", "valid_from, valid_to"},
+	}
+	advisoryHistoryIndexes = []index{
+		{"vah_vulnerability_advisories_idx", "vulnerability_id, platform, segment, package, valid_to"},
+		{"vah_source_package_idx", "platform, segment, package"},
+		{"vah_valid_idx", "valid_from, valid_to"},
+	}
+)
+
+// createHistoryTables creates the history tables whose names are given.
+// The values are not compressed even in a compact database, as the history tables are not meant for distribution.
+// The keys and the values are written as TEXT so that the history can be queried with string literals.
+func (m *Sqlite) createHistoryTables(ctx context.Context) error {
+	if m.vulnHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        vulnerability_id TEXT NOT NULL,
+        value TEXT NOT NULL,
+        value_hash TEXT NOT NULL DEFAULT '',
+        title TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL DEFAULT '',
+        published_date TIMESTAMP,
+        last_modified_date TIMESTAMP,
+        cvss_v2_vector TEXT NOT NULL DEFAULT '',
+        cvss_v2_score REAL,
+        cvss_v3_vector TEXT NOT NULL DEFAULT '',
+        cvss_v3_score REAL,
+        valid_from TIMESTAMP NOT NULL,
+        valid_to TIMESTAMP
+    );`, m.vulnHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		if err := createIndexes(ctx, m.db, m.vulnHistoryTableName, vulnHistoryIndexes); err != nil {
+			return err
+		}
+	}
+	if m.advisoryHistoryTableName != "" {
+		stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        vulnerability_id TEXT NOT NULL,
+        platform TEXT NOT NULL,
+        segment TEXT NOT NULL,
+        package TEXT NOT NULL,
+        value TEXT NOT NULL,
+        value_hash TEXT NOT NULL DEFAULT '',
+        fixed_version TEXT NOT NULL DEFAULT '',
+        affected_version TEXT NOT NULL DEFAULT '',
+        vulnerable_versions TEXT NOT NULL DEFAULT '[]',
+        patched_versions TEXT NOT NULL DEFAULT '[]',
+        unaffected_versions TEXT NOT NULL DEFAULT '[]',
+        status TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL DEFAULT '',
+        valid_from TIMESTAMP NOT NULL,
+        valid_to TIMESTAMP
+    );`, m.advisoryHistoryTableName)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		if err := createIndexes(ctx, m.db, m.advisoryHistoryTableName, advisoryHistoryIndexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sqlite) VulnHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s WHERE valid_to IS NULL;", m.vulnHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Sqlite) VulnAdvisoryHistoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s WHERE valid_to IS NULL;", m.advisoryHistoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Sqlite) InsertVulnHistory(ctx context.Context, validFrom time.Time, vulns [][][]byte) error {
+	for _, c := range drivers.Chunk(vulns, 12, maxPlaceholders) {
+		if err := m.insertVulnHistory(ctx, validFrom, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sqlite) insertVulnHistory(ctx context.Context, validFrom time.Time, vulns [][][]byte) error {
+	var iv []string
+	for i := 0; i < len(vulns); i++ {
+		iv = append(iv, placeholders(i*12, 12))
+	}
+	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,value,value_hash,%s,valid_from) VALUES %s", m.vulnHistoryTableName, vulnerabilityColumns, strings.Join(iv, ",")) //nolint:gosec
+
+	var values []interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		values = append(values, string(vuln[0]), string(vuln[1]), drivers.Hash(vuln[1]))
+		values = append(values, vulnerabilityValues(v)...)
+		values = append(values, timestamp(validFrom))
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) InsertVulnAdvisoryHistory(ctx context.Context, validFrom time.Time, secAdvisories [][][]byte) error {
+	for _, c := range drivers.Chunk(secAdvisories, 14, maxPlaceholders) {
+		if err := m.insertVulnAdvisoryHistory(ctx, validFrom, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sqlite) insertVulnAdvisoryHistory(ctx context.Context, validFrom time.Time, secAdvisories [][][]byte) error {
+	var iv []string
+	for i := 0; i < len(secAdvisories); i++ {
+		iv = append(iv, placeholders(i*14, 14))
+	}
+	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,platform,segment,package,value,value_hash,%s,valid_from) VALUES %s", m.advisoryHistoryTableName, advisoryColumns, strings.Join(iv, ",")) //nolint:gosec
+
+	var values []interface{}
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		values = append(values, string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]), string(secAdvisory[4]),
+			drivers.Hash(secAdvisory[4]))
+		values = append(values, advisoryValues(a)...)
+		values = append(values, timestamp(validFrom))
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) CloseVulnHistory(ctx context.Context, validTo time.Time, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = $1 WHERE vulnerability_id = $2 AND valid_to IS NULL;", m.vulnHistoryTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{timestamp(validTo), string(vuln[0])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) CloseVulnAdvisoryHistory(ctx context.Context, validTo time.Time, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET valid_to = $1 WHERE vulnerability_id = $2 AND platform = $3 AND segment = $4 AND package = $5 AND valid_to IS NULL;", m.advisoryHistoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{timestamp(validTo), string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
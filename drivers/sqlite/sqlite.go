@@ -7,121 +7,323 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
 )
 
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type index struct {
+	name    string
+	columns string
+}
+
+var (
+	vulnerabilitiesIndexes = []index{
+		{"v_vulnerability_id_idx", "vulnerability_id"},
+		{"v_severity_idx", "severity"},
+		{"v_published_date_idx", "published_date"},
+		{"v_last_modified_date_idx", "last_modified_date"},
+	}
+	advisoryIndexes = []index{
+		{"va_vulnerability_advisories_idx", "vulnerability_id, platform, segment, package"},
+		{"va_vulnerability_id_idx", "vulnerability_id"},
+		{"va_platform_idx", "platform"},
+		{"va_source_idx", "platform, segment"},
+		{"va_source_package_idx", "platform, segment, package"},
+		{"va_status_idx", "status"},
+	}
+	dataSourceIndexes = []index{
+		{"v_source_key_idx", "source_key"},
+	}
+	cvssIndexes = []index{
+		{"vc_vulnerability_id_idx", "vulnerability_id"},
+		{"vc_source_idx", "source"},
+	}
+	referencesIndexes = []index{
+		{"vr_vulnerability_id_idx", "vulnerability_id"},
+	}
+	cwesIndexes = []index{
+		{"vcw_vulnerability_id_idx", "vulnerability_id"},
+		{"vcw_cwe_id_idx", "cwe_id"},
+	}
+	javaArtifactsIndexes = []index{
+		{"ja_group_id_artifact_id_idx", "group_id, artifact_id"},
+	}
+	javaIndicesIndexes = []index{
+		{"ji_artifact_id_idx", "artifact_id"},
+		{"ji_sha1_idx", "sha1"},
+	}
+	changesIndexes = []index{
+		{"vch_run_id_idx", "run_id"},
+		{"vch_vulnerability_id_idx", "vulnerability_id"},
+		{"vch_source_package_idx", "platform, segment, package"},
+		{"vch_recorded_at_idx", "recorded_at"},
+	}
+)
+
+const (
+	// maxPlaceholders is SQLITE_MAX_VARIABLE_NUMBER of modernc.org/sqlite
+	maxPlaceholders = 32766
+
+	vulnerabilityColumns = "title,severity,published_date,last_modified_date,cvss_v2_vector,cvss_v2_score,cvss_v3_vector,cvss_v3_score"
+	advisoryColumns      = "fixed_version,affected_version,vulnerable_versions,patched_versions,unaffected_versions,status,severity"
+	cvssColumns          = "v2_vector,v2_score,v3_vector,v3_score,v4_vector,v4_score"
+
+	// textKeysVersion is the user_version of the databases whose key columns are written as TEXT
+	textKeysVersion = 1
+)
+
+type pragma struct {
+	name  string
+	value string
+}
+
+// loadPragmas are set on the connection of a load and restored after it.
+var loadPragmas = []pragma{
+	{"journal_mode", "WAL"},
+	{"synchronous", "OFF"},
+	// 256 MiB
+	{"cache_size", "-262144"},
+	{"temp_store", "MEMORY"},
+}
+
 type Sqlite struct {
 	db                       *sql.DB
 	vulnerabilitiesTableName string
 	advisoryTableName        string
 	dataSourceTableName      string
+	metadataTableName        string
+	changesTableName         string
+	vulnHistoryTableName     string
+	advisoryHistoryTableName string
+	cvssTableName            string
+	referencesTableName      string
+	cwesTableName            string
+	javaArtifactsTableName   string
+	javaIndicesTableName     string
+	advisoryValuesTableName  string
+	shadow                   bool
+	compact                  bool
+	// conn and tx are the connection and the transaction of the running load
+	conn     *sql.Conn
+	tx       *sql.Tx
+	restores []pragma
 }
 
 // New return *Sqlite
-func New(db *sql.DB, vulnerabilitiesTableName, advisoryTableName string, dataSourceTableName string) (*Sqlite, error) {
+func New(db *sql.DB, tableNames drivers.TableNames, opts drivers.Options) (*Sqlite, error) {
 	return &Sqlite{
 		db:                       db,
-		vulnerabilitiesTableName: vulnerabilitiesTableName,
-		advisoryTableName:        advisoryTableName,
-		dataSourceTableName:      dataSourceTableName,
+		vulnerabilitiesTableName: tableNames.Vulnerabilities,
+		advisoryTableName:        tableNames.Advisories,
+		dataSourceTableName:      tableNames.DataSource,
+		metadataTableName:        tableNames.Metadata,
+		changesTableName:         tableNames.Changes,
+		vulnHistoryTableName:     tableNames.VulnHistory,
+		advisoryHistoryTableName: tableNames.AdvisoryHistory,
+		cvssTableName:            tableNames.CVSS,
+		referencesTableName:      tableNames.References,
+		cwesTableName:            tableNames.CWEs,
+		javaArtifactsTableName:   tableNames.JavaArtifacts,
+		javaIndicesTableName:     tableNames.JavaIndices,
+		advisoryValuesTableName:  tableNames.Advisories + "_values",
+		compact:                  opts.SQLiteCompact,
 	}, nil
 }
 
-func (m *Sqlite) createTables(ctx context.Context) error {
-	if err := m.createVulnerabilitiesTable(ctx); err != nil {
-		return err
-	}
-
-	if err := m.createAdvisoryTable(ctx); err != nil {
-		return err
-	}
-
-	if err := m.createDataSourceTable(ctx); err != nil {
-		return err
+func (m *Sqlite) createTables(ctx context.Context, tables []table) error {
+	for _, t := range tables {
+		if err := t.create(ctx, m.db, t.name); err != nil {
+			return err
+		}
+		if err := createIndexes(ctx, m.db, t.name, t.indexes); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (m *Sqlite) createVulnerabilitiesTable(ctx context.Context) error {
+func (m *Sqlite) createVulnerabilitiesTable(ctx context.Context, ex execer, table string) error {
+	valueType := "TEXT"
+	if m.compact {
+		valueType = "BLOB"
+	}
 	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         vulnerability_id TEXT NOT NULL,
-        value TEXT NOT NULL,
+        value %s NOT NULL,
+        value_hash TEXT NOT NULL DEFAULT '',
+        title TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL DEFAULT '',
+        published_date TIMESTAMP,
+        last_modified_date TIMESTAMP,
+        cvss_v2_vector TEXT NOT NULL DEFAULT '',
+        cvss_v2_score REAL,
+        cvss_v3_vector TEXT NOT NULL DEFAULT '',
+        cvss_v3_score REAL,
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );`, m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
-		return err
-	}
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS v_vulnerability_id_idx ON %s(vulnerability_id);", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+    );`, table, valueType)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *Sqlite) createAdvisoryTable(ctx context.Context) error {
+func (m *Sqlite) createAdvisoryTable(ctx context.Context, ex execer, table string) error {
+	// values of a compact database are stored in the advisory values table
+	value := "\n        value TEXT NOT NULL,"
+	if m.compact {
+		value = ""
+	}
 	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         vulnerability_id TEXT NOT NULL,
         platform TEXT NOT NULL,
         segment TEXT NOT NULL,
-        package TEXT NOT NULL,
-        value TEXT NOT NULL,
+        package TEXT NOT NULL,%s
+        value_hash TEXT NOT NULL DEFAULT '',
+        fixed_version TEXT NOT NULL DEFAULT '',
+        affected_version TEXT NOT NULL DEFAULT '',
+        vulnerable_versions TEXT NOT NULL DEFAULT '[]',
+        patched_versions TEXT NOT NULL DEFAULT '[]',
+        unaffected_versions TEXT NOT NULL DEFAULT '[]',
+        status TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL DEFAULT '',
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );`, m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+    );`, table, value)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS va_vulnerability_advisories_idx ON %s(vulnerability_id, platform, segment, package);", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	return nil
+}
+
+func (m *Sqlite) createDataSourceTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        source_key TEXT NOT NULL,
+        source_id TEXT NOT NULL,
+        source_name TEXT NOT NULL,
+        source_url TEXT NOT NULL,
+        value_hash TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS va_vulnerability_id_idx ON %s(vulnerability_id);", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	return nil
+}
+
+func (m *Sqlite) createCVSSTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        vulnerability_id TEXT NOT NULL,
+        source TEXT NOT NULL,
+        v2_vector TEXT NOT NULL DEFAULT '',
+        v2_score REAL,
+        v3_vector TEXT NOT NULL DEFAULT '',
+        v3_score REAL,
+        v4_vector TEXT NOT NULL DEFAULT '',
+        v4_score REAL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS va_platform_idx ON %s(platform);", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	return nil
+}
+
+func (m *Sqlite) createReferencesTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        vulnerability_id TEXT NOT NULL,
+        url TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS va_source_idx ON %s(platform, segment);", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	return nil
+}
+
+func (m *Sqlite) createCWEsTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        vulnerability_id TEXT NOT NULL,
+        cwe_id TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS va_source_package_idx ON %s(platform, segment, package);", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+	return nil
+}
+
+func (m *Sqlite) createJavaArtifactsTable(ctx context.Context, ex execer, table string) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY,
+        group_id TEXT NOT NULL,
+        artifact_id TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+
 	return nil
 }
 
-func (m *Sqlite) createDataSourceTable(ctx context.Context) error {
+func (m *Sqlite) createJavaIndicesTable(ctx context.Context, ex execer, table string) error {
 	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
-        source_key TEXT NOT NULL,
-        source_id TEXT NOT NULL,
-        source_name TEXT NOT NULL,
-        source_url TEXT NOT NULL,
+        artifact_id INTEGER NOT NULL,
+        version TEXT NOT NULL,
+        sha1 TEXT NOT NULL,
+        archive_type TEXT NOT NULL,
         created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );`, m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
-		return err
-	}
-	stmt = fmt.Sprintf("CREATE INDEX IF NOT EXISTS v_source_key_idx ON %s(source_key);", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+    );`, table)
+	if _, err := ex.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// createIndexes creates indexes on table. Index names are unique per database in SQLite,
+// so shadow tables get their indexes only after they are swapped in.
+func createIndexes(ctx context.Context, ex execer, table string, indexes []index) error {
+	for _, idx := range indexes {
+		stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s);", idx.name, table, idx.columns)
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *Sqlite) Migrate(ctx context.Context) error {
+	if err := m.createMetadataTable(ctx); err != nil {
+		return err
+	}
+	if m.changesTableName != "" {
+		if err := m.createChangesTable(ctx); err != nil {
+			return err
+		}
+	}
+	if err := m.createHistoryTables(ctx); err != nil {
+		return err
+	}
+
 	var count int
 	stmt := fmt.Sprintf("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name IN ('%s', '%s','%s');",
 		m.vulnerabilitiesTableName, m.advisoryTableName, m.dataSourceTableName) //nolint:gosec
@@ -132,155 +334,774 @@ func (m *Sqlite) Migrate(ctx context.Context) error {
 
 	switch count {
 	case 3:
-		// Migrate from v1
-		stmt = fmt.Sprintf(`PRAGMA foreign_keys=off;
-            BEGIN TRANSACTION;
-            CREATE TEMPORARY TABLE %s_backup(id INTEGER PRIMARY KEY AUTOINCREMENT, vulnerability_id TEXT, value TEXT, created_at TIMESTAMP);
-            INSERT INTO %s_backup SELECT * FROM %s;
-            DROP TABLE %s;
-            CREATE TABLE %s(id INTEGER PRIMARY KEY AUTOINCREMENT, vulnerability_id TEXT, value TEXT, created_at TIMESTAMP);
-            INSERT INTO %s SELECT * FROM %s_backup;
-            DROP TABLE %s_backup;
-            COMMIT;
-            PRAGMA foreign_keys=on;`, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName, m.vulnerabilitiesTableName)
-		if _, err := m.db.Exec(stmt); err != nil {
-			return err
-		}
-
-		stmt = fmt.Sprintf(`PRAGMA foreign_keys=off;
-            BEGIN TRANSACTION;
-            CREATE TEMPORARY TABLE %s_backup(id INTEGER PRIMARY KEY AUTOINCREMENT, vulnerability_id TEXT, platform TEXT, segment TEXT, package TEXT, value TEXT, created_at TIMESTAMP);
-            INSERT INTO %s_backup SELECT * FROM %s;
-            DROP TABLE %s;
-            CREATE TABLE %s(id INTEGER PRIMARY KEY AUTOINCREMENT, vulnerability_id TEXT, platform TEXT, segment TEXT, package TEXT, value TEXT, created_at TIMESTAMP);
-            INSERT INTO %s SELECT * FROM %s_backup;
-            DROP TABLE %s_backup;
-            COMMIT;
-            PRAGMA foreign_keys=on;`, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName, m.advisoryTableName)
-		if _, err := m.db.Exec(stmt); err != nil {
-			return err
-		}
-
-		stmt = fmt.Sprintf(`PRAGMA foreign_keys=off;
-            BEGIN TRANSACTION;
-            CREATE TEMPORARY TABLE %s_backup(id INTEGER PRIMARY KEY AUTOINCREMENT, source_key TEXT, source_id TEXT, source_name TEXT, source_url TEXT, created_at TIMESTAMP);
-            INSERT INTO %s_backup SELECT * FROM %s;
-            DROP TABLE %s;
-            CREATE TABLE %s(id INTEGER PRIMARY KEY AUTOINCREMENT, source_key TEXT, source_id TEXT, source_name TEXT, source_url TEXT, created_at TIMESTAMP);
-            INSERT INTO %s SELECT * FROM %s_backup;
-            DROP TABLE %s_backup;
-            COMMIT;
-            PRAGMA foreign_keys=on;`, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName, m.dataSourceTableName)
-		if _, err := m.db.Exec(stmt); err != nil {
+		// SQLite support was added after the v2 schema, so only columns added later are migrated
+		if err := m.addColumns(ctx); err != nil {
 			return err
 		}
-
-		return nil
+		return m.castKeys(ctx)
 	case 1:
 		return errors.New("invalid table schema")
 	}
 
-	return m.createTables(ctx)
+	if err := m.createTables(ctx, m.tables()); err != nil {
+		return err
+	}
+	return m.setUserVersion(ctx, textKeysVersion)
+}
+
+func (m *Sqlite) MigrateJavaDB(ctx context.Context) error {
+	return m.createTables(ctx, m.javaDBTables())
 }
 
-func (m *Sqlite) InsertVuln(ctx context.Context, vulns [][][]byte) error {
-	var iv []string
-	for i := 0; i < len(vulns); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d)", i*2+1, i*2+2))
+// addColumns adds the columns and indexes added after v2 to the existing tables.
+func (m *Sqlite) addColumns(ctx context.Context) error {
+	columns := []struct {
+		table      string
+		name       string
+		definition string
+	}{
+		{m.vulnerabilitiesTableName, "value_hash", "TEXT NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "value_hash", "TEXT NOT NULL DEFAULT ''"},
+		{m.dataSourceTableName, "value_hash", "TEXT NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "title", "TEXT NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "severity", "TEXT NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "published_date", "TIMESTAMP"},
+		{m.vulnerabilitiesTableName, "last_modified_date", "TIMESTAMP"},
+		{m.vulnerabilitiesTableName, "cvss_v2_vector", "TEXT NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v2_score", "REAL"},
+		{m.vulnerabilitiesTableName, "cvss_v3_vector", "TEXT NOT NULL DEFAULT ''"},
+		{m.vulnerabilitiesTableName, "cvss_v3_score", "REAL"},
+		{m.advisoryTableName, "fixed_version", "TEXT NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "affected_version", "TEXT NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "vulnerable_versions", "TEXT NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "patched_versions", "TEXT NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "unaffected_versions", "TEXT NOT NULL DEFAULT '[]'"},
+		{m.advisoryTableName, "status", "TEXT NOT NULL DEFAULT ''"},
+		{m.advisoryTableName, "severity", "TEXT NOT NULL DEFAULT ''"},
 	}
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,value) VALUES %s", m.vulnerabilitiesTableName, strings.Join(iv, ",")) //nolint:gosec
+	changed := map[string]bool{}
+	for _, c := range columns {
+		added, err := m.addColumnIfNotExists(ctx, c.table, c.name, c.definition)
+		if err != nil {
+			return err
+		}
+		changed[c.table] = changed[c.table] || added
+	}
+	for _, t := range m.vulnChildTables() {
+		exists, err := m.tableExists(ctx, t.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := t.create(ctx, m.db, t.name); err != nil {
+			return err
+		}
+		if err := createIndexes(ctx, m.db, t.name, t.indexes); err != nil {
+			return err
+		}
+		// rows of the new table are derived from vulnerabilities
+		changed[m.vulnerabilitiesTableName] = true
+	}
+	if err := m.resetHashes(ctx, changed); err != nil {
+		return err
+	}
+	for _, t := range m.tables() {
+		if err := createIndexes(ctx, m.db, t.name, t.indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	ins, err := m.db.Prepare(query)
-	if err != nil {
+// castKeys converts the key columns written as BLOB by earlier versions to TEXT,
+// so that the keys match the string arguments of incremental loads and string literals.
+// The conversion scans the tables, so it runs once and is recorded in user_version.
+func (m *Sqlite) castKeys(ctx context.Context) error {
+	var version int
+	if err := m.db.QueryRowContext(ctx, "PRAGMA user_version;").Scan(&version); err != nil {
 		return err
 	}
+	if version >= textKeysVersion {
+		return nil
+	}
+	keys := []struct {
+		table   string
+		columns []string
+	}{
+		{m.vulnerabilitiesTableName, []string{"vulnerability_id"}},
+		{m.advisoryTableName, []string{"vulnerability_id", "platform", "segment", "package"}},
+		{m.dataSourceTableName, []string{"source_key"}},
+		{m.cvssTableName, []string{"vulnerability_id", "source"}},
+		{m.referencesTableName, []string{"vulnerability_id", "url"}},
+		{m.cwesTableName, []string{"vulnerability_id", "cwe_id"}},
+	}
+	for _, k := range keys {
+		var sets, blobs []string
+		for _, c := range k.columns {
+			sets = append(sets, fmt.Sprintf("%s = CAST(%s AS TEXT)", c, c))
+			blobs = append(blobs, fmt.Sprintf("typeof(%s) = 'blob'", c))
+		}
+		stmt := fmt.Sprintf("UPDATE %s SET %s WHERE %s;", k.table, strings.Join(sets, ", "), strings.Join(blobs, " OR ")) //nolint:gosec
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return m.setUserVersion(ctx, textKeysVersion)
+}
 
-	var values []interface{}
-	for _, vuln := range vulns {
-		values = append(values, vuln[0], vuln[1])
+func (m *Sqlite) setUserVersion(ctx context.Context, version int) error {
+	_, err := m.db.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d;", version))
+	return err
+}
+
+func (m *Sqlite) addColumnIfNotExists(ctx context.Context, table, column, definition string) (bool, error) {
+	var count int
+	stmt := fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?;", table) //nolint:gosec
+	if err := m.db.QueryRowContext(ctx, stmt, column).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	stmt = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *Sqlite) tableExists(ctx context.Context, table string) (bool, error) {
+	var count int
+	stmt := "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name = $1;"
+	if err := m.db.QueryRowContext(ctx, stmt, table).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// resetHashes clears value_hash of the tables with added columns,
+// so that the next incremental load fills the new columns of all rows.
+func (m *Sqlite) resetHashes(ctx context.Context, tables map[string]bool) error {
+	for table, changed := range tables {
+		if !changed {
+			continue
+		}
+		stmt := fmt.Sprintf("UPDATE %s SET value_hash = '';", table)
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
 	}
-	{
-		_, err := ins.Exec(values...)
+	return nil
+}
+
+func (m *Sqlite) createMetadataTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        trivy_db_version INTEGER NOT NULL,
+        updated_at TIMESTAMP,
+        next_update TIMESTAMP,
+        downloaded_at TIMESTAMP,
+        trivy_db_to_version TEXT NOT NULL,
+        row_counts TEXT NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );`, m.metadataTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
 		return err
 	}
+	return nil
 }
 
-func (m *Sqlite) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
-	// 定义一个结构体用于反序列化 JSON
-	type Item struct {
-		ID   string `json:"ID"`
-		Name string `json:"Name"`
-		URL  string `json:"URL"`
+func (m *Sqlite) createChangesTable(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        run_id TEXT NOT NULL,
+        recorded_at TIMESTAMP NOT NULL,
+        change_type TEXT NOT NULL,
+        vulnerability_id TEXT NOT NULL,
+        platform TEXT NOT NULL,
+        segment TEXT NOT NULL,
+        package TEXT NOT NULL,
+        fixed_version TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL DEFAULT ''
+    );`, m.changesTableName)
+	if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	return createIndexes(ctx, m.db, m.changesTableName, changesIndexes)
+}
+
+func (m *Sqlite) InsertVuln(ctx context.Context, vulns [][][]byte) error {
+	for _, c := range drivers.Chunk(vulns, 11, maxPlaceholders) {
+		if err := m.insertVuln(ctx, c); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+func (m *Sqlite) insertVuln(ctx context.Context, vulns [][][]byte) error {
 	var iv []string
-	for i := 0; i < len(dataSources); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
+	for i := 0; i < len(vulns); i++ {
+		iv = append(iv, placeholders(i*11, 11))
 	}
-	query := fmt.Sprintf("INSERT INTO %s(source_key,source_id,source_name,source_url) VALUES %s", m.dataSourceTableName, strings.Join(iv, ",")) //nolint:gosec
+	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,value,value_hash,%s) VALUES %s", m.tableName(m.vulnerabilitiesTableName), vulnerabilityColumns, strings.Join(iv, ",")) //nolint:gosec
 
-	ins, err := m.db.Prepare(query)
-	if err != nil {
-		return err
+	var values []interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		values = append(values, string(vuln[0]), m.value(vuln[1]), drivers.Hash(vuln[1]))
+		values = append(values, vulnerabilityValues(v)...)
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) InsertDataSource(ctx context.Context, dataSources [][][]byte) error {
+	var iv []string
+	for i := 0; i < len(dataSources); i++ {
+		iv = append(iv, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5))
 	}
+	query := fmt.Sprintf("INSERT INTO %s(source_key,source_id,source_name,source_url,value_hash) VALUES %s", m.tableName(m.dataSourceTableName), strings.Join(iv, ",")) //nolint:gosec
 
 	var values []interface{}
 	for _, dataSource := range dataSources {
-		var item Item
-		if err = json.Unmarshal(dataSource[1], &item); err != nil {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
 			return err
 		}
-		values = append(values, dataSource[0], item.ID, item.Name, item.URL)
-	}
-	{
-		_, err = ins.Exec(values...)
-		return err
+		values = append(values, string(dataSource[0]), item.ID, item.Name, item.URL, drivers.Hash(dataSource[1]))
 	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
 }
 
 func (m *Sqlite) InsertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	for _, c := range drivers.Chunk(secAdvisories, 13, maxPlaceholders) {
+		if err := m.insertVulnAdvisory(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sqlite) insertVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	columns, n := "vulnerability_id,platform,segment,package,value,value_hash", 13
+	if m.compact {
+		if err := m.insertAdvisoryValues(ctx, secAdvisories); err != nil {
+			return err
+		}
+		columns, n = "vulnerability_id,platform,segment,package,value_hash", 12
+	}
 	var iv []string
 	for i := 0; i < len(secAdvisories); i++ {
-		iv = append(iv, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5))
+		iv = append(iv, placeholders(i*n, n))
 	}
 
-	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,platform,segment,package,value) VALUES %s", m.advisoryTableName, strings.Join(iv, ",")) //nolint:gosec
-	ins, err := m.db.Prepare(query)
+	query := fmt.Sprintf("INSERT INTO %s(%s,%s) VALUES %s", m.tableName(m.advisoryTableName), columns, advisoryColumns, strings.Join(iv, ",")) //nolint:gosec
+	var values []interface{}
+	for _, secAdvisory := range secAdvisories {
+		a, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		values = append(values, string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3]))
+		if !m.compact {
+			values = append(values, secAdvisory[4])
+		}
+		values = append(values, drivers.Hash(secAdvisory[4]))
+		values = append(values, advisoryValues(a)...)
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) InsertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	for _, c := range drivers.Chunk(cvss, 8, maxPlaceholders) {
+		if err := m.insertVulnCVSS(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Sqlite) insertVulnCVSS(ctx context.Context, cvss [][][]byte) error {
+	var iv []string
+	for i := 0; i < len(cvss); i++ {
+		iv = append(iv, placeholders(i*8, 8))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s(vulnerability_id,source,%s) VALUES %s", m.tableName(m.cvssTableName), cvssColumns, strings.Join(iv, ",")) //nolint:gosec
+	var values []interface{}
+	for _, c := range cvss {
+		v, err := drivers.ParseCVSS(c[2])
+		if err != nil {
+			return err
+		}
+		values = append(values, string(c[0]), string(c[1]))
+		values = append(values, cvssValues(v)...)
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) InsertVulnReferences(ctx context.Context, references [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.referencesTableName, "url", references)
+}
+
+func (m *Sqlite) InsertVulnCWEs(ctx context.Context, cwes [][][]byte) error {
+	return m.insertVulnChildRows(ctx, m.cwesTableName, "cwe_id", cwes)
+}
+
+// insertVulnChildRows inserts rows of [vulnerability_id, column] into table.
+func (m *Sqlite) insertVulnChildRows(ctx context.Context, table, column string, rows [][][]byte) error {
+	return m.insertRows(ctx, table, []string{"vulnerability_id", column}, rows)
+}
+
+func (m *Sqlite) InsertJavaArtifacts(ctx context.Context, artifacts [][][]byte) error {
+	return m.insertRows(ctx, m.javaArtifactsTableName, []string{"id", "group_id", "artifact_id"}, artifacts)
+}
+
+func (m *Sqlite) InsertJavaIndices(ctx context.Context, indices [][][]byte) error {
+	return m.insertRows(ctx, m.javaIndicesTableName, []string{"artifact_id", "version", "sha1", "archive_type"}, indices)
+}
+
+// insertRows inserts rows holding the values of columns into table. Values are bound as TEXT
+// so that they match string literals and column affinity applies (e.g. to INTEGER PRIMARY KEY).
+func (m *Sqlite) insertRows(ctx context.Context, table string, columns []string, rows [][][]byte) error {
+	n := len(columns)
+	for _, c := range drivers.Chunk(rows, n, maxPlaceholders) {
+		var iv []string
+		var values []interface{}
+		for i, r := range c {
+			iv = append(iv, placeholders(i*n, n))
+			for _, v := range r {
+				values = append(values, string(v))
+			}
+		}
+		query := fmt.Sprintf("INSERT INTO %s(%s) VALUES %s", m.tableName(table), strings.Join(columns, ","), strings.Join(iv, ",")) //nolint:gosec
+		if _, err := m.execer().ExecContext(ctx, query, values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execer returns the transaction of the running load, or the database outside a load.
+func (m *Sqlite) execer() execer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
+func (m *Sqlite) InsertMetadata(ctx context.Context, metadata drivers.Metadata) error {
+	counts, err := json.Marshal(metadata.RowCounts)
 	if err != nil {
 		return err
 	}
+	stmt := fmt.Sprintf("INSERT INTO %s(trivy_db_version,updated_at,next_update,downloaded_at,trivy_db_to_version,row_counts) VALUES ($1,$2,$3,$4,$5,$6);", m.metadataTableName) //nolint:gosec
+	if _, err := m.db.ExecContext(ctx, stmt, metadata.Version, timestamp(metadata.UpdatedAt), timestamp(metadata.NextUpdate),
+		timestamp(metadata.DownloadedAt), metadata.TrivyDBToVersion, string(counts)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Sqlite) InsertVulnAdvisoryChanges(ctx context.Context, runID string, recordedAt time.Time, changes [][][]byte) error {
+	for _, c := range drivers.Chunk(changes, 10, maxPlaceholders) {
+		if err := m.insertVulnAdvisoryChanges(ctx, runID, recordedAt, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func (m *Sqlite) insertVulnAdvisoryChanges(ctx context.Context, runID string, recordedAt time.Time, changes [][][]byte) error {
+	var iv []string
+	for i := 0; i < len(changes); i++ {
+		iv = append(iv, placeholders(i*10, 10))
+	}
+	query := fmt.Sprintf("INSERT INTO %s(run_id,recorded_at,change_type,vulnerability_id,platform,segment,package,fixed_version,status,severity) VALUES %s", m.changesTableName, strings.Join(iv, ",")) //nolint:gosec
 	var values []interface{}
+	for _, c := range changes {
+		a, err := drivers.ParseAdvisoryChange(c)
+		if err != nil {
+			return err
+		}
+		values = append(values, runID, timestamp(recordedAt), string(c[0]), string(c[1]), string(c[2]), string(c[3]), string(c[4]), a.FixedVersion, a.Status, a.Severity)
+	}
+	_, err := m.execer().ExecContext(ctx, query, values...)
+	return err
+}
+
+func (m *Sqlite) VulnHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, value_hash FROM %s;", m.vulnerabilitiesTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Sqlite) VulnAdvisoryHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT vulnerability_id, platform, segment, package, value_hash FROM %s;", m.advisoryTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 4)
+}
+
+func (m *Sqlite) DataSourceHashes(ctx context.Context) (map[string]string, error) {
+	stmt := fmt.Sprintf("SELECT source_key, value_hash FROM %s;", m.dataSourceTableName)
+	rows, err := m.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	return drivers.ScanHashes(rows, 1)
+}
+
+func (m *Sqlite) UpdateVuln(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = $1, value_hash = $2, title = $3, severity = $4, published_date = $5, last_modified_date = $6, "+
+		"cvss_v2_vector = $7, cvss_v2_score = $8, cvss_v3_vector = $9, cvss_v3_score = $10 WHERE vulnerability_id = $11;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		v, err := drivers.ParseVulnerability(vuln[1])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{vuln[1], drivers.Hash(vuln[1])}, vulnerabilityValues(v)...)
+		args = append(args, append(a, string(vuln[0])))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) UpdateVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET value = $1, value_hash = $2, fixed_version = $3, affected_version = $4, vulnerable_versions = $5, patched_versions = $6, "+
+		"unaffected_versions = $7, status = $8, severity = $9 WHERE vulnerability_id = $10 AND platform = $11 AND segment = $12 AND package = $13;", m.advisoryTableName)
+	var args [][]interface{}
 	for _, secAdvisory := range secAdvisories {
-		values = append(values, secAdvisory[0], secAdvisory[1], secAdvisory[2], secAdvisory[3], secAdvisory[4])
+		adv, err := drivers.ParseAdvisory(secAdvisory[4])
+		if err != nil {
+			return err
+		}
+		a := append([]interface{}{secAdvisory[4], drivers.Hash(secAdvisory[4])}, advisoryValues(adv)...)
+		args = append(args, append(a, string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3])))
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) UpdateDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("UPDATE %s SET source_id = $1, source_name = $2, source_url = $3, value_hash = $4 WHERE source_key = $5;", m.dataSourceTableName)
+	var args [][]interface{}
+	for _, dataSource := range dataSources {
+		var item drivers.DataSource
+		if err := json.Unmarshal(dataSource[1], &item); err != nil {
+			return err
+		}
+		args = append(args, []interface{}{item.ID, item.Name, item.URL, drivers.Hash(dataSource[1]), string(dataSource[0])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) DeleteVulns(ctx context.Context, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1;", m.vulnerabilitiesTableName)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{string(vuln[0])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) DeleteVulnAdvisories(ctx context.Context, secAdvisories [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1 AND platform = $2 AND segment = $3 AND package = $4;", m.advisoryTableName)
+	var args [][]interface{}
+	for _, secAdvisory := range secAdvisories {
+		args = append(args, []interface{}{string(secAdvisory[0]), string(secAdvisory[1]), string(secAdvisory[2]), string(secAdvisory[3])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) DeleteDataSource(ctx context.Context, dataSources [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE source_key = $1;", m.dataSourceTableName)
+	var args [][]interface{}
+	for _, dataSource := range dataSources {
+		args = append(args, []interface{}{string(dataSource[0])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) DeleteVulnCVSS(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cvssTableName, vulns)
+}
+
+func (m *Sqlite) DeleteVulnReferences(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.referencesTableName, vulns)
+}
+
+func (m *Sqlite) DeleteVulnCWEs(ctx context.Context, vulns [][][]byte) error {
+	return m.deleteVulnChildRows(ctx, m.cwesTableName, vulns)
+}
+
+// deleteVulnChildRows deletes the rows of vulnerabilities from table.
+func (m *Sqlite) deleteVulnChildRows(ctx context.Context, table string, vulns [][][]byte) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE vulnerability_id = $1;", table)
+	var args [][]interface{}
+	for _, vuln := range vulns {
+		args = append(args, []interface{}{string(vuln[0])})
+	}
+	return drivers.ExecEach(ctx, m.db, stmt, args)
+}
+
+func (m *Sqlite) CreateShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.tables())
+}
+
+// SwapTables drops the tables, renames the shadow tables and builds their indexes in the transaction of the load.
+func (m *Sqlite) SwapTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.tables())
+}
+
+func (m *Sqlite) DropShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.tables())
+}
+
+func (m *Sqlite) CreateJavaDBShadowTables(ctx context.Context) error {
+	return m.createShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Sqlite) SwapJavaDBTables(ctx context.Context) error {
+	return m.swapTables(ctx, m.javaDBTables())
+}
+
+func (m *Sqlite) DropJavaDBShadowTables(ctx context.Context) error {
+	return m.dropShadowTables(ctx, m.javaDBTables())
+}
+
+func (m *Sqlite) createShadowTables(ctx context.Context, tables []table) error {
+	if err := m.dropShadowTables(ctx, tables); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if err := t.create(ctx, m.db, drivers.ShadowName(t.name)); err != nil {
+			return err
+		}
 	}
-	{
-		_, err := ins.Exec(values...)
+	if err := m.beginLoad(ctx); err != nil {
 		return err
 	}
+	m.shadow = true
+	return nil
 }
 
-func (m *Sqlite) TruncateVulns(ctx context.Context) error {
-	stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", m.vulnerabilitiesTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+// beginLoad sets loadPragmas on a dedicated connection and begins the transaction that all inserts of the load share.
+// If it fails, the pragmas are restored and the connection is released.
+func (m *Sqlite) beginLoad(ctx context.Context) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	m.conn = conn
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, m.endLoad(ctx))
+		}
+	}()
+	for _, p := range loadPragmas {
+		var current string
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("PRAGMA %s;", p.name)).Scan(&current); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s;", p.name, p.value)); err != nil {
+			return err
+		}
+		m.restores = append([]pragma{{p.name, current}}, m.restores...)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
+	m.tx = tx
+	return nil
+}
 
-	return m.createVulnerabilitiesTable(ctx)
+// endLoad restores the pragmas changed by beginLoad and releases the connection of the load.
+// It attempts every restore even if one fails.
+func (m *Sqlite) endLoad(ctx context.Context) error {
+	if m.conn == nil {
+		return nil
+	}
+	conn := m.conn
+	restores := m.restores
+	m.conn, m.tx, m.restores = nil, nil, nil
+	var errs []error
+	for _, p := range restores {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s;", p.name, p.value)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
-func (m *Sqlite) TruncateVulnAdvisories(ctx context.Context) error {
-	stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", m.advisoryTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+// swapTables commits the rows of the load with the swap, then updates the statistics of the tables.
+func (m *Sqlite) swapTables(ctx context.Context, tables []table) error {
+	if err := m.commitSwap(ctx, tables); err != nil {
 		return err
 	}
-	return m.createAdvisoryTable(ctx)
+	for _, t := range tables {
+		if _, err := m.db.ExecContext(ctx, fmt.Sprintf("ANALYZE %s;", t.name)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (m *Sqlite) TruncateDataSource(ctx context.Context) error {
-	stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", m.dataSourceTableName)
-	if _, err := m.db.Exec(stmt); err != nil {
+func (m *Sqlite) commitSwap(ctx context.Context, tables []table) error {
+	tx := m.tx
+	if tx == nil {
+		var err error
+		tx, err = m.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+	defer func() {
+		_ = tx.Rollback()
+		_ = m.endLoad(ctx)
+	}()
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", t.name)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		stmt = fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", drivers.ShadowName(t.name), t.name)
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+		if err := createIndexes(ctx, tx, t.name, t.indexes); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
 		return err
 	}
-	return m.createDataSourceTable(ctx)
+	m.shadow = false
+	return m.endLoad(ctx)
+}
+
+func (m *Sqlite) dropShadowTables(ctx context.Context, tables []table) error {
+	if m.tx != nil {
+		// the transaction of an unfinished load locks the database
+		if err := m.tx.Rollback(); err != nil {
+			return err
+		}
+		if err := m.endLoad(ctx); err != nil {
+			return err
+		}
+	}
+	for _, t := range tables {
+		stmt := fmt.Sprintf("DROP TABLE IF EXISTS %s;", drivers.ShadowName(t.name))
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	m.shadow = false
+	return nil
+}
+
+type table struct {
+	name    string
+	create  func(ctx context.Context, ex execer, table string) error
+	indexes []index
+}
+
+func (m *Sqlite) tables() []table {
+	if m.compact {
+		return append([]table{
+			{m.vulnerabilitiesTableName, m.createVulnerabilitiesTable, vulnerabilitiesIndexes},
+			{m.advisoryTableName, m.createAdvisoryTable, compactAdvisoryIndexes},
+			{m.advisoryValuesTableName, m.createAdvisoryValuesTable, nil},
+			{m.dataSourceTableName, m.createDataSourceTable, dataSourceIndexes},
+		}, m.vulnChildTables()...)
+	}
+	return append([]table{
+		{m.vulnerabilitiesTableName, m.createVulnerabilitiesTable, vulnerabilitiesIndexes},
+		{m.advisoryTableName, m.createAdvisoryTable, advisoryIndexes},
+		{m.dataSourceTableName, m.createDataSourceTable, dataSourceIndexes},
+	}, m.vulnChildTables()...)
+}
+
+// vulnChildTables returns the tables whose rows are derived from vulnerabilities.
+func (m *Sqlite) vulnChildTables() []table {
+	return []table{
+		{m.cvssTableName, m.createCVSSTable, cvssIndexes},
+		{m.referencesTableName, m.createReferencesTable, referencesIndexes},
+		{m.cwesTableName, m.createCWEsTable, cwesIndexes},
+	}
+}
+
+// javaDBTables returns the tables of Trivy Java DB. They are loaded separately from the tables of Trivy DB.
+func (m *Sqlite) javaDBTables() []table {
+	return []table{
+		{m.javaArtifactsTableName, m.createJavaArtifactsTable, javaArtifactsIndexes},
+		{m.javaIndicesTableName, m.createJavaIndicesTable, javaIndicesIndexes},
+	}
+}
+
+// value returns the JSON value of a row as stored in the database. It is compressed in a compact database.
+func (m *Sqlite) value(v []byte) interface{} {
+	if m.compact {
+		return compressValue(v)
+	}
+	return v
+}
+
+// vulnerabilityValues returns the values of vulnerabilityColumns.
+func vulnerabilityValues(v drivers.Vulnerability) []interface{} {
+	return []interface{}{v.Title, v.Severity, timestamp(v.PublishedDate.Time), timestamp(v.LastModifiedDate.Time),
+		v.CVSSV2Vector, v.CVSSV2Score, v.CVSSV3Vector, v.CVSSV3Score}
+}
+
+// advisoryValues returns the values of advisoryColumns.
+func advisoryValues(a drivers.Advisory) []interface{} {
+	return []interface{}{a.FixedVersion, a.AffectedVersion, drivers.JSONArray(a.VulnerableVersions), drivers.JSONArray(a.PatchedVersions),
+		drivers.JSONArray(a.UnaffectedVersions), a.Status, a.Severity}
+}
+
+// cvssValues returns the values of cvssColumns.
+func cvssValues(c drivers.CVSS) []interface{} {
+	return []interface{}{c.V2Vector, c.V2Score, c.V3Vector, c.V3Score, c.V4Vector, c.V4Score}
+}
+
+// placeholders returns "($offset+1, ..., $offset+n)".
+func placeholders(offset, n int) string {
+	var p []string
+	for i := 1; i <= n; i++ {
+		p = append(p, fmt.Sprintf("$%d", offset+i))
+	}
+	return "(" + strings.Join(p, ", ") + ")"
+}
+
+// timestamp formats t in the same format as CURRENT_TIMESTAMP. It returns nil for the zero time.
+func timestamp(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func (m *Sqlite) tableName(name string) string {
+	if m.shadow {
+		return drivers.ShadowName(name)
+	}
+	return name
 }
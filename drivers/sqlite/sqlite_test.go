@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	_ "modernc.org/sqlite"
+)
+
+func TestSwapTables(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "trivy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := New(db, drivers.DefaultTableNames(), drivers.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// an unfinished load is rolled back
+	if err := m.CreateShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00001"), []byte(`{"Title":"dropped"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DropShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.CreateShadowTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var mode string
+	if err := m.tx.QueryRowContext(ctx, "PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("got journal_mode %s during the load, want wal", mode)
+	}
+	if err := m.InsertVuln(ctx, [][][]byte{{[]byte("CVE-2023-00002"), []byte(`{"Title":"loaded"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertDataSource(ctx, [][][]byte{{[]byte("debian 12"), []byte(`{"ID":"debian","Name":"Debian Security Tracker","URL":"https://salsa.debian.org/security-tracker-team/security-tracker"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SwapTables(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA journal_mode;").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "delete" {
+		t.Errorf("got journal_mode %s after the load, want delete", mode)
+	}
+
+	var title string
+	if err := db.QueryRow("SELECT group_concat(title) FROM vulnerabilities").Scan(&title); err != nil {
+		t.Fatal(err)
+	}
+	if title != "loaded" {
+		t.Errorf("got %q, want %q", title, "loaded")
+	}
+	var stats int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_stat1 WHERE tbl = 'vulnerabilities'").Scan(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats == 0 {
+		t.Error("vulnerabilities is not analyzed")
+	}
+}
+
+func TestStringKeys(t *testing.T) {
+	ctx := context.Background()
+	for _, compact := range []bool{false, true} {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "trivy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		m, err := New(db, drivers.DefaultTableNames(), drivers.Options{SQLiteCompact: compact})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.CreateShadowTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.InsertVulnAdvisory(ctx, [][][]byte{{[]byte("CVE-2023-00001"), []byte("debian"), []byte("12"), []byte("openssl"), []byte(`{"FixedVersion":"3.0.9-1"}`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapTables(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		const query = "SELECT COUNT(*) FROM vulnerability_advisories WHERE vulnerability_id = 'CVE-2023-00001' AND package = 'openssl'"
+		var count int
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("compact %v: got %d advisories matching string literals, want 1", compact, count)
+		}
+
+		// the keys written as BLOB by earlier versions are converted by Migrate once
+		if _, err := db.Exec("UPDATE vulnerability_advisories SET vulnerability_id = CAST(vulnerability_id AS BLOB), package = CAST(package AS BLOB)"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("compact %v: got %d advisories converted in a database created as TEXT, want 0", compact, count)
+		}
+		if _, err := db.Exec("PRAGMA user_version = 0"); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("compact %v: got %d migrated advisories matching string literals, want 1", compact, count)
+		}
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != textKeysVersion {
+			t.Errorf("compact %v: got user_version %d, want %d", compact, version, textKeysVersion)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package drivers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const keySep = "\x00"
+
+// Syncer is a Driver that can apply only the changed rows to the tables.
+type Syncer interface {
+	Driver
+
+	// VulnHashes returns content hashes of vulnerabilities keyed by Key(vulnerability_id).
+	VulnHashes(ctx context.Context) (map[string]string, error)
+	// VulnAdvisoryHashes returns content hashes of advisories keyed by Key(vulnerability_id, platform, segment, package).
+	VulnAdvisoryHashes(ctx context.Context) (map[string]string, error)
+	// DataSourceHashes returns content hashes of data sources keyed by Key(source_key).
+	DataSourceHashes(ctx context.Context) (map[string]string, error)
+
+	UpdateVuln(ctx context.Context, vulns [][][]byte) error
+	UpdateVulnAdvisory(ctx context.Context, secAdvisories [][][]byte) error
+	UpdateDataSource(ctx context.Context, dataSources [][][]byte) error
+
+	// DeleteVulns deletes vulnerabilities. Each row holds the key columns only.
+	DeleteVulns(ctx context.Context, vulns [][][]byte) error
+	DeleteVulnAdvisories(ctx context.Context, secAdvisories [][][]byte) error
+	DeleteDataSource(ctx context.Context, dataSources [][][]byte) error
+	// DeleteVulnCVSS deletes the CVSS rows of vulnerabilities. Each row holds vulnerability_id only.
+	DeleteVulnCVSS(ctx context.Context, vulns [][][]byte) error
+	DeleteVulnReferences(ctx context.Context, vulns [][][]byte) error
+	DeleteVulnCWEs(ctx context.Context, vulns [][][]byte) error
+}
+
+// ChangeRecorder is a Syncer that can append the advisories added, removed or modified by each run to the changes table.
+type ChangeRecorder interface {
+	Syncer
+
+	// InsertVulnAdvisoryChanges appends rows of [change_type, vulnerability_id, platform, segment, package, value]
+	// recorded by the run of runID at recordedAt. value is empty for removed advisories.
+	InsertVulnAdvisoryChanges(ctx context.Context, runID string, recordedAt time.Time, changes [][][]byte) error
+}
+
+// HistoryKeeper is a Driver that keeps the history of the vulnerabilities and the advisories in the history tables.
+// A row of a history table is valid from valid_from until valid_to, which is NULL while the row is current.
+type HistoryKeeper interface {
+	Driver
+
+	// VulnHistoryHashes and VulnAdvisoryHistoryHashes return the content hashes of the current rows by key.
+	VulnHistoryHashes(ctx context.Context) (map[string]string, error)
+	VulnAdvisoryHistoryHashes(ctx context.Context) (map[string]string, error)
+	// InsertVulnHistory and InsertVulnAdvisoryHistory insert the rows as current rows valid from validFrom.
+	InsertVulnHistory(ctx context.Context, validFrom time.Time, vulns [][][]byte) error
+	InsertVulnAdvisoryHistory(ctx context.Context, validFrom time.Time, secAdvisories [][][]byte) error
+	// CloseVulnHistory and CloseVulnAdvisoryHistory close the current rows of the keys of the rows at validTo.
+	CloseVulnHistory(ctx context.Context, validTo time.Time, vulns [][][]byte) error
+	CloseVulnAdvisoryHistory(ctx context.Context, validTo time.Time, secAdvisories [][][]byte) error
+}
+
+// ParseAdvisoryChange extracts the columns from the value of a row of InsertVulnAdvisoryChanges.
+// A removed advisory has no value, so its columns are empty.
+func ParseAdvisoryChange(change [][]byte) (Advisory, error) {
+	if len(change[5]) == 0 {
+		return Advisory{}, nil
+	}
+	return ParseAdvisory(change[5])
+}
+
+// DataSource is a value of the data-source bucket.
+type DataSource struct {
+	ID   string `json:"ID"`
+	Name string `json:"Name"`
+	URL  string `json:"URL"`
+}
+
+// Hash returns the content hash of a value stored in Trivy DB.
+func Hash(value []byte) string {
+	s := sha256.Sum256(value)
+	return hex.EncodeToString(s[:])
+}
+
+// Key joins key columns of a row into a map key.
+func Key(columns ...string) string {
+	return strings.Join(columns, keySep)
+}
+
+// SplitKey splits a map key built by Key into key columns.
+func SplitKey(key string) [][]byte {
+	var columns [][]byte
+	for _, c := range strings.Split(key, keySep) {
+		columns = append(columns, []byte(c))
+	}
+	return columns
+}
+
+// ScanHashes reads rows of key columns followed by a hash column.
+func ScanHashes(rows *sql.Rows, keyColumns int) (map[string]string, error) {
+	defer rows.Close()
+	hashes := map[string]string{}
+	keys := make([]string, keyColumns)
+	dest := make([]interface{}, keyColumns+1)
+	for i := range keys {
+		dest[i] = &keys[i]
+	}
+	var hash sql.NullString
+	dest[keyColumns] = &hash
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		hashes[Key(keys...)] = hash.String
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// ExecEach executes stmt once per argument list in a single transaction.
+func ExecEach(ctx context.Context, db *sql.DB, stmt string, args [][]interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	s, err := tx.PrepareContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for _, a := range args {
+		if _, err := s.ExecContext(ctx, a...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
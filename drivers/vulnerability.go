@@ -0,0 +1,74 @@
+package drivers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+
+	"github.com/aquasecurity/trivy-db/pkg/types"
+)
+
+const nvdSourceID = "nvd"
+
+// Vulnerability holds the columns extracted from a value of the vulnerability bucket.
+type Vulnerability struct {
+	Title            string
+	Severity         string
+	PublishedDate    sql.NullTime
+	LastModifiedDate sql.NullTime
+	CVSSV2Vector     string
+	CVSSV2Score      sql.NullFloat64
+	CVSSV3Vector     string
+	CVSSV3Score      sql.NullFloat64
+}
+
+// ParseVulnerability extracts the columns from a value of the vulnerability bucket.
+// CVSS is taken from NVD if available, otherwise from the first source in alphabetical order.
+func ParseVulnerability(value []byte) (Vulnerability, error) {
+	var v types.Vulnerability
+	if err := json.Unmarshal(value, &v); err != nil {
+		return Vulnerability{}, err
+	}
+	vuln := Vulnerability{
+		Title:    v.Title,
+		Severity: v.Severity,
+	}
+	if vuln.Severity == "" {
+		if s, ok := v.VendorSeverity[nvdSourceID]; ok {
+			vuln.Severity = s.String()
+		}
+	}
+	if v.PublishedDate != nil {
+		vuln.PublishedDate = NullTime(*v.PublishedDate)
+	}
+	if v.LastModifiedDate != nil {
+		vuln.LastModifiedDate = NullTime(*v.LastModifiedDate)
+	}
+	for _, id := range cvssSourceIDs(v.CVSS) {
+		c := v.CVSS[id]
+		if vuln.CVSSV2Vector == "" && c.V2Vector != "" {
+			vuln.CVSSV2Vector = c.V2Vector
+			vuln.CVSSV2Score = sql.NullFloat64{Float64: c.V2Score, Valid: true}
+		}
+		if vuln.CVSSV3Vector == "" && c.V3Vector != "" {
+			vuln.CVSSV3Vector = c.V3Vector
+			vuln.CVSSV3Score = sql.NullFloat64{Float64: c.V3Score, Valid: true}
+		}
+	}
+	return vuln, nil
+}
+
+// cvssSourceIDs returns the source IDs of cvss with NVD first.
+func cvssSourceIDs(cvss types.VendorCVSS) []types.SourceID {
+	var ids []types.SourceID
+	for id := range cvss {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i] == nvdSourceID || ids[j] == nvdSourceID {
+			return ids[i] == nvdSourceID
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
@@ -0,0 +1,49 @@
+package drivers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseVulnerability(t *testing.T) {
+	published := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in               string
+		wantSeverity     string
+		wantPublished    bool
+		wantCVSSV2Vector string
+		wantCVSSV3Vector string
+		wantCVSSV3Score  float64
+	}{
+		{
+			`{"Severity":"HIGH","PublishedDate":"2023-01-02T03:04:05Z","CVSS":{"ghsa":{"V3Vector":"CVSS:3.1/AV:L","V3Score":5.5},"nvd":{"V2Vector":"AV:N","V2Score":7.5,"V3Vector":"CVSS:3.1/AV:N","V3Score":9.8}}}`,
+			"HIGH", true, "AV:N", "CVSS:3.1/AV:N", 9.8,
+		},
+		{
+			`{"VendorSeverity":{"nvd":2},"CVSS":{"redhat":{"V3Vector":"CVSS:3.1/AV:L","V3Score":5.5},"ghsa":{"V3Vector":"CVSS:3.1/AV:A","V3Score":6.5}}}`,
+			"MEDIUM", false, "", "CVSS:3.1/AV:A", 6.5,
+		},
+		{
+			`{}`,
+			"", false, "", "", 0,
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseVulnerability([]byte(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Severity != tt.wantSeverity {
+			t.Errorf("ParseVulnerability(%s) Severity = %s, want %s", tt.in, got.Severity, tt.wantSeverity)
+		}
+		if got.PublishedDate.Valid != tt.wantPublished || (tt.wantPublished && !got.PublishedDate.Time.Equal(published)) {
+			t.Errorf("ParseVulnerability(%s) PublishedDate = %v", tt.in, got.PublishedDate)
+		}
+		if got.CVSSV2Vector != tt.wantCVSSV2Vector {
+			t.Errorf("ParseVulnerability(%s) CVSSV2Vector = %s, want %s", tt.in, got.CVSSV2Vector, tt.wantCVSSV2Vector)
+		}
+		if got.CVSSV3Vector != tt.wantCVSSV3Vector || got.CVSSV3Score.Float64 != tt.wantCVSSV3Score {
+			t.Errorf("ParseVulnerability(%s) CVSSV3 = %s %v, want %s %v", tt.in, got.CVSSV3Vector, got.CVSSV3Score.Float64, tt.wantCVSSV3Vector, tt.wantCVSSV3Score)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aquasecurity/trivy/pkg/log"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ExtractTrivyDB extracts trivy.db and metadata.json from archive into dir and returns the path of trivy.db.
+// archive is either db.tar.gz of the Trivy DB OCI artifact or an OCI image layout tarball holding it as a layer.
+// Both gzip-compressed and plain tarballs are accepted.
+func ExtractTrivyDB(archive, dir string) (string, error) {
+	log.Logger.Infof("Extracting Trivy DB from %s ...", archive)
+	f, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	found, err := extractTar(f, dir, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", archive, err)
+	}
+	if !found {
+		return "", fmt.Errorf("%s not found in %s", trivyDBFile, archive)
+	}
+	return filepath.Join(dir, trivyDBFile), nil
+}
+
+// extractTar writes trivy.db and metadata.json found in the tarball r into dir and reports whether trivy.db was found.
+// If nested is true, blobs of an OCI image layout are searched as well.
+func extractTar(r io.Reader, dir string, nested bool) (bool, error) {
+	r, err := decompress(r)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return found, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		switch name := filepath.Base(hdr.Name); {
+		case name == trivyDBFile || name == metadataFile:
+			if err := writeFile(filepath.Join(dir, name), tr); err != nil {
+				return false, err
+			}
+			found = found || name == trivyDBFile
+		case nested && strings.HasPrefix(path.Clean(filepath.ToSlash(hdr.Name)), "blobs/"):
+			// manifests and configs are not tarballs, so errors of the blobs are ignored
+			if ok, err := extractTar(tr, dir, false); err == nil && ok {
+				found = true
+			}
+		}
+	}
+}
+
+// decompress returns r as is unless it is gzip-compressed.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+func writeFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractTrivyDB(t *testing.T) {
+	dbTarGz := tarball(t, true, map[string][]byte{trivyDBFile: []byte("db"), metadataFile: []byte(`{"Version":2}`)})
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"db.tar.gz", dbTarGz},
+		{"db.tar", tarball(t, false, map[string][]byte{"./" + trivyDBFile: []byte("db")})},
+		{"OCI image layout", tarball(t, false, map[string][]byte{
+			"oci-layout":            []byte(`{"imageLayoutVersion":"1.0.0"}`),
+			"blobs/sha256/manifest": []byte(`{"schemaVersion":2}`),
+			"blobs/sha256/layer":    dbTarGz,
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "archive")
+			if err := os.WriteFile(archive, tt.archive, 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ExtractTrivyDB(archive, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "db" {
+				t.Errorf("got %q, want %q", b, "db")
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive")
+	if err := os.WriteFile(archive, tarball(t, true, map[string][]byte{metadataFile: []byte("{}")}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractTrivyDB(archive, dir); err == nil {
+		t.Error("want error for an archive without trivy.db")
+	}
+}
+
+func tarball(t *testing.T, compress bool, files map[string][]byte) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	var w io.Writer = buf
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for name, b := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(b)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
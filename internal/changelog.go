@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+// changeLog appends the advisories added, removed or modified by a run to the changes table.
+// The rows passed to it are written before Trivy DB is closed, so they are not copied.
+type changeLog struct {
+	recorder   drivers.ChangeRecorder
+	runID      string
+	recordedAt time.Time
+	changes    [][][]byte
+}
+
+func newChangeLog(recorder drivers.ChangeRecorder) *changeLog {
+	return &changeLog{
+		recorder:   recorder,
+		runID:      uuid.NewString(),
+		recordedAt: time.Now().UTC(),
+	}
+}
+
+// record returns a function that calls fn, if any, and records the advisories as change.
+// Removed advisories are keys of [vulnerability_id, platform, segment, package] without a value.
+func (l *changeLog) record(change string, fn func(ctx context.Context, rows [][][]byte) error) func(ctx context.Context, rows [][][]byte) error {
+	return func(ctx context.Context, rows [][][]byte) error {
+		if fn != nil {
+			if err := fn(ctx, rows); err != nil {
+				return err
+			}
+		}
+		for _, r := range rows {
+			c := [][]byte{[]byte(change), r[0], r[1], r[2], r[3], nil}
+			if len(r) > 4 {
+				c[5] = r[4]
+			}
+			l.changes = append(l.changes, c)
+		}
+		return l.flush(ctx, chunkSize)
+	}
+}
+
+func (l *changeLog) flush(ctx context.Context, size int) error {
+	if len(l.changes) == 0 || len(l.changes) < size {
+		return nil
+	}
+	if err := l.recorder.InsertVulnAdvisoryChanges(ctx, l.runID, l.recordedAt, l.changes); err != nil {
+		return err
+	}
+	l.changes = nil
+	return nil
+}
+
+// swapDiffer returns a differ that records the changes of the advisories loaded by a swap load against hashes of
+// the advisories the swap replaces. The changes are written during the load, so that the drivers commit them
+// with the swap and a failed load records no changes.
+func (l *changeLog) swapDiffer(hashes map[string]string) *differ {
+	return &differ{
+		hashes: hashes,
+		insert: l.record(ChangeAdded, nil),
+		update: l.record(ChangeModified, nil),
+		delete: l.record(ChangeRemoved, nil),
+	}
+}
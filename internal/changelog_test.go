@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	_ "modernc.org/sqlite"
+)
+
+func TestRecordChanges(t *testing.T) {
+	ctx := context.Background()
+	vulns := map[string]string{"CVE-2023-0001": `{"Severity":"HIGH"}`, "CVE-2023-0002": `{"Severity":"LOW"}`}
+	oldPath := writeTrivyDB(t, vulns, map[string]map[string]map[string]string{
+		"debian 12": {"openssl": {"CVE-2023-0001": `{"Status":3}`, "CVE-2023-0002": `{"Status":3}`}},
+	})
+	newPath := writeTrivyDB(t, vulns, map[string]map[string]map[string]string{
+		"debian 12": {"openssl": {"CVE-2023-0001": `{"FixedVersion":"3.0.9-1","Status":2}`}, "curl": {"CVE-2023-0002": `{"Status":3}`}},
+	})
+	tableNames := drivers.TableNames{
+		Vulnerabilities: "vulnerabilities",
+		Advisories:      "vulnerability_advisories",
+		DataSource:      "data_source",
+		Metadata:        "metadata",
+		Changes:         "vulnerability_changes",
+		CVSS:            "vulnerability_cvss",
+		References:      "vulnerability_references",
+		CWEs:            "vulnerability_cwes",
+	}
+	for _, loadMode := range []string{LoadModeSwap, LoadModeIncremental} {
+		t.Run(loadMode, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "target.db")
+			dsn := "sqlite://" + target
+			if err := InitDB(ctx, dsn, tableNames); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{oldPath, newPath} {
+				if err := UpdateDB(ctx, path, dsn, tableNames, nil, loadMode, drivers.Options{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			db, err := sql.Open("sqlite", target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			rows, err := db.Query(`SELECT change_type, vulnerability_id, package, fixed_version FROM vulnerability_changes
+WHERE run_id = (SELECT run_id FROM vulnerability_changes ORDER BY id DESC LIMIT 1) ORDER BY change_type, vulnerability_id, package`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+			var got []string
+			for rows.Next() {
+				var change, id, pkg, fixed string
+				if err := rows.Scan(&change, &id, &pkg, &fixed); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, change+" "+id+" "+pkg+" "+fixed)
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				"added CVE-2023-0002 curl ",
+				"modified CVE-2023-0001 openssl 3.0.9-1",
+				"removed CVE-2023-0002 openssl ",
+			}
+			if len(got) != len(want) {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("got %q, want %q", got[i], want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aquasecurity/trivy/pkg/log"
+	"github.com/klauspost/compress/zstd"
+	"github.com/xo/dburl"
+)
+
+const (
+	CompressZstd = "zstd"
+	CompressGzip = "gzip"
+)
+
+var compressExts = map[string]string{
+	CompressZstd: ".zst",
+	CompressGzip: ".gz",
+}
+
+// VacuumSQLite rebuilds the SQLite database of dsn with VACUUM, which rewrites the whole file,
+// so it is run once after all loads of a run. DSNs of other datasources are ignored.
+func VacuumSQLite(ctx context.Context, dsn string) error {
+	if _, _, ok := dumpDSN(dsn); ok {
+		return nil
+	}
+	if _, _, ok := fileDSN(dsn); ok {
+		return nil
+	}
+	db, d, err := dbOpen(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if d != "sqlite" {
+		return nil
+	}
+	log.Logger.Info("Vacuuming the database ...")
+	if _, err := db.ExecContext(ctx, "VACUUM;"); err != nil {
+		return err
+	}
+	log.Logger.Info("done")
+	return nil
+}
+
+// CompressedPath returns the path of the SQLite database of dsn compressed in format.
+func CompressedPath(dsn, format string) (string, error) {
+	ext, ok := compressExts[format]
+	if !ok {
+		return "", fmt.Errorf("unsupported compression format '%s'", format)
+	}
+	u, err := dburl.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	if u.Driver != "sqlite" && u.Driver != "sqlite3" {
+		return "", fmt.Errorf("compression is only supported for SQLite, not '%s'", u.Driver)
+	}
+	p, _, _ := strings.Cut(strings.TrimPrefix(u.DSN, "file:"), "?")
+	return p + ext, nil
+}
+
+// CompressSQLite compresses the SQLite database of dsn in format and writes the SHA-256 checksum
+// of the compressed file next to it in the format of sha256sum. It returns the path of the compressed file.
+func CompressSQLite(dsn, format string) (string, error) {
+	out, err := CompressedPath(dsn, format)
+	if err != nil {
+		return "", err
+	}
+	src := strings.TrimSuffix(out, compressExts[format])
+	log.Logger.Infof("Compressing %s into %s ...", src, out)
+	sum, err := compressFile(src, out, format)
+	if err != nil {
+		return "", err
+	}
+	checksum := fmt.Sprintf("%x  %s\n", sum, filepath.Base(out))
+	if err := os.WriteFile(out+".sha256", []byte(checksum), 0644); err != nil { //nolint:gosec
+		return "", err
+	}
+	log.Logger.Info("done")
+	return out, nil
+}
+
+// compressFile writes src compressed in format to dst and returns the SHA-256 digest of dst.
+func compressFile(src, dst, format string) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	// the compressed file is meant to be distributed
+	if err := tmp.Chmod(0644); err != nil { //nolint:gosec
+		return nil, err
+	}
+
+	h := sha256.New()
+	var w io.WriteCloser
+	switch format {
+	case CompressZstd:
+		w, err = zstd.NewWriter(io.MultiWriter(tmp, h), zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		w, err = gzip.NewWriterLevel(io.MultiWriter(tmp, h), gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
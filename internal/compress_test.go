@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressSQLite(t *testing.T) {
+	tests := []struct {
+		format string
+		ext    string
+		reader func(r io.Reader) (io.Reader, error)
+	}{
+		{CompressZstd, ".zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{CompressGzip, ".gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			db := filepath.Join(t.TempDir(), "trivy.db")
+			if err := os.WriteFile(db, []byte("sqlite"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := CompressSQLite("sqlite://"+db, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := db + tt.ext; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+			b, err := os.ReadFile(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checksum, err := os.ReadFile(got + ".sha256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("%x  trivy.db%s\n", sha256.Sum256(b), tt.ext); string(checksum) != want {
+				t.Errorf("got %q, want %q", checksum, want)
+			}
+			f, err := os.Open(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r, err := tt.reader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "sqlite" {
+				t.Errorf("got %q, want %q", content, "sqlite")
+			}
+		})
+	}
+
+	if _, err := CompressedPath("postgres://localhost/trivydb", CompressZstd); err == nil {
+		t.Error("want error for PostgreSQL")
+	}
+}
+
+func TestVacuumSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trivy.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE t (v BLOB);",
+		"INSERT INTO t VALUES (zeroblob(1048576));",
+		"DELETE FROM t;",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freePages := func() int {
+		var n int
+		if err := db.QueryRow("PRAGMA freelist_count;").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if freePages() == 0 {
+		t.Fatal("no free pages to vacuum")
+	}
+	if err := VacuumSQLite(context.Background(), "sqlite://"+path); err != nil {
+		t.Fatal(err)
+	}
+	if n := freePages(); n != 0 {
+		t.Errorf("got %d free pages after VACUUM, want 0", n)
+	}
+}
@@ -0,0 +1,382 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// ChangeAdded is a row that exists in the new generation only.
+	ChangeAdded = "added"
+	// ChangeRemoved is a row that exists in the old generation only.
+	ChangeRemoved = "removed"
+	// ChangeModified is a row whose value differs between the generations.
+	ChangeModified = "modified"
+
+	kindVulnerability = "vulnerability"
+	kindAdvisory      = "advisory"
+)
+
+const (
+	// DiffFormatTable writes changes as a table of aligned columns.
+	DiffFormatTable = "table"
+	// DiffFormatJSON writes changes as a JSON array.
+	DiffFormatJSON = "json"
+	// DiffFormatMarkdown writes changes as a Markdown table following a summary.
+	DiffFormatMarkdown = "markdown"
+)
+
+// Change is a vulnerability or an advisory that was added, removed or modified between two generations of Trivy DB.
+type Change struct {
+	Change          string `json:"change"`
+	Kind            string `json:"kind"`
+	VulnerabilityID string `json:"vulnerability_id"`
+	Platform        string `json:"platform,omitempty"`
+	Segment         string `json:"segment,omitempty"`
+	Package         string `json:"package,omitempty"`
+	// Fields holds the changed columns of a modified row. It is empty if the old value is unknown,
+	// or if only columns that are not compared (such as the description) changed.
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a column of a modified row.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// generation holds the rows of the old generation by Key: the values if it is Trivy DB,
+// or the content hashes if it is a datasource.
+type generation struct {
+	values map[string][]byte
+	hashes map[string]string
+}
+
+// pop removes key from g and returns its old value (nil if only its hash is known),
+// whether it exists and whether value differs from it.
+func (g generation) pop(key string, value []byte) ([]byte, bool, bool) {
+	if g.values != nil {
+		old, ok := g.values[key]
+		delete(g.values, key)
+		return old, ok, ok && !bytes.Equal(old, value)
+	}
+	hash, ok := g.hashes[key]
+	delete(g.hashes, key)
+	return nil, ok, ok && hash != drivers.Hash(value)
+}
+
+// keys returns the keys left in g, which do not exist in the new generation.
+func (g generation) keys() []string {
+	var keys []string
+	for k := range g.values {
+		keys = append(keys, k)
+	}
+	for k := range g.hashes {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// DiffDB compares Trivy DB at oldPath with Trivy DB at newPath.
+func DiffDB(ctx context.Context, oldPath, newPath string, targetSources []string) ([]Change, error) {
+	sourceRe, err := compileSources(targetSources)
+	if err != nil {
+		return nil, err
+	}
+	oldDb, err := openTrivyDB(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	defer oldDb.Close()
+	newDb, err := openTrivyDB(newPath)
+	if err != nil {
+		return nil, err
+	}
+	defer newDb.Close()
+
+	var changes []Change
+	// the values of the old generation refer to its memory map, so they are compared while it is viewed
+	if err := oldDb.View(func(oldTx *bolt.Tx) error {
+		oldVulns := generation{values: map[string][]byte{}}
+		if err := walkVulns(oldTx, func(vulns [][][]byte) error {
+			for _, vuln := range vulns {
+				oldVulns.values[drivers.Key(string(vuln[0]))] = vuln[1]
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		oldAdvisories := generation{values: map[string][]byte{}}
+		if err := walkAdvisories(oldTx, sourceRe, func(secAdv [][][]byte) error {
+			for _, a := range secAdv {
+				oldAdvisories.values[drivers.Key(string(a[0]), string(a[1]), string(a[2]), string(a[3]))] = a[4]
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return newDb.View(func(tx *bolt.Tx) error {
+			changes, err = diffGenerations(tx, oldVulns, oldAdvisories, sourceRe)
+			return err
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// DiffDSN compares the tables of the datasource with Trivy DB at dbPath, which would be loaded into them.
+// The datasource keeps the content hashes of the rows only, so the changed columns of modified rows are not reported.
+func DiffDSN(ctx context.Context, dsn, dbPath string, tableNames drivers.TableNames, targetSources []string) ([]Change, error) {
+	sourceRe, err := compileSources(targetSources)
+	if err != nil {
+		return nil, err
+	}
+	driver, d, closeDriver, err := openDriver(dsn, tableNames, drivers.Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer closeDriver()
+	syncer, ok := driver.(drivers.Syncer)
+	if !ok {
+		return nil, fmt.Errorf("driver '%s' does not support diff", d)
+	}
+	vulnHashes, err := syncer.VulnHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	advisoryHashes, err := syncer.VulnAdvisoryHashes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trivyDb, err := openTrivyDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer trivyDb.Close()
+	var changes []Change
+	if err := trivyDb.View(func(tx *bolt.Tx) error {
+		changes, err = diffGenerations(tx, generation{hashes: vulnHashes}, generation{hashes: advisoryHashes}, sourceRe)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// diffGenerations compares the rows of the old generation with Trivy DB of tx. It returns the changes sorted by key.
+func diffGenerations(tx *bolt.Tx, oldVulns, oldAdvisories generation, sourceRe []*regexp.Regexp) ([]Change, error) {
+	var changes []Change
+	if err := walkVulns(tx, func(vulns [][][]byte) error {
+		for _, vuln := range vulns {
+			old, ok, modified := oldVulns.pop(drivers.Key(string(vuln[0])), vuln[1])
+			switch {
+			case !ok:
+				changes = append(changes, Change{Change: ChangeAdded, Kind: kindVulnerability, VulnerabilityID: string(vuln[0])})
+			case modified:
+				c := Change{Change: ChangeModified, Kind: kindVulnerability, VulnerabilityID: string(vuln[0])}
+				if old != nil {
+					fields, err := vulnFieldChanges(old, vuln[1])
+					if err != nil {
+						return err
+					}
+					c.Fields = fields
+				}
+				changes = append(changes, c)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, key := range oldVulns.keys() {
+		changes = append(changes, Change{Change: ChangeRemoved, Kind: kindVulnerability, VulnerabilityID: string(drivers.SplitKey(key)[0])})
+	}
+
+	advisoryChange := func(change string, columns [][]byte) Change {
+		return Change{
+			Change:          change,
+			Kind:            kindAdvisory,
+			VulnerabilityID: string(columns[0]),
+			Platform:        string(columns[1]),
+			Segment:         string(columns[2]),
+			Package:         string(columns[3]),
+		}
+	}
+	if err := walkAdvisories(tx, sourceRe, func(secAdv [][][]byte) error {
+		for _, a := range secAdv {
+			old, ok, modified := oldAdvisories.pop(drivers.Key(string(a[0]), string(a[1]), string(a[2]), string(a[3])), a[4])
+			switch {
+			case !ok:
+				changes = append(changes, advisoryChange(ChangeAdded, a))
+			case modified:
+				c := advisoryChange(ChangeModified, a)
+				if old != nil {
+					fields, err := advisoryFieldChanges(old, a[4])
+					if err != nil {
+						return err
+					}
+					c.Fields = fields
+				}
+				changes = append(changes, c)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for _, key := range oldAdvisories.keys() {
+		columns := drivers.SplitKey(key)
+		if len(columns) != 4 {
+			return nil, fmt.Errorf("invalid advisory key %q", key)
+		}
+		changes = append(changes, advisoryChange(ChangeRemoved, columns))
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Kind != b.Kind {
+			return a.Kind == kindVulnerability
+		}
+		return drivers.Key(a.VulnerabilityID, a.Platform, a.Segment, a.Package) < drivers.Key(b.VulnerabilityID, b.Platform, b.Segment, b.Package)
+	})
+	return changes, nil
+}
+
+// vulnFieldChanges returns the changed columns of a vulnerability.
+func vulnFieldChanges(oldValue, newValue []byte) ([]FieldChange, error) {
+	o, err := drivers.ParseVulnerability(oldValue)
+	if err != nil {
+		return nil, err
+	}
+	n, err := drivers.ParseVulnerability(newValue)
+	if err != nil {
+		return nil, err
+	}
+	return changedFields([]FieldChange{
+		{"title", o.Title, n.Title},
+		{"severity", o.Severity, n.Severity},
+		{"cvss_v2_score", formatScore(o.CVSSV2Score.Float64, o.CVSSV2Score.Valid), formatScore(n.CVSSV2Score.Float64, n.CVSSV2Score.Valid)},
+		{"cvss_v3_score", formatScore(o.CVSSV3Score.Float64, o.CVSSV3Score.Valid), formatScore(n.CVSSV3Score.Float64, n.CVSSV3Score.Valid)},
+	}), nil
+}
+
+// advisoryFieldChanges returns the changed columns of an advisory.
+func advisoryFieldChanges(oldValue, newValue []byte) ([]FieldChange, error) {
+	o, err := drivers.ParseAdvisory(oldValue)
+	if err != nil {
+		return nil, err
+	}
+	n, err := drivers.ParseAdvisory(newValue)
+	if err != nil {
+		return nil, err
+	}
+	return changedFields([]FieldChange{
+		{"fixed_version", o.FixedVersion, n.FixedVersion},
+		{"affected_version", o.AffectedVersion, n.AffectedVersion},
+		{"vulnerable_versions", joinVersions(o.VulnerableVersions), joinVersions(n.VulnerableVersions)},
+		{"patched_versions", joinVersions(o.PatchedVersions), joinVersions(n.PatchedVersions)},
+		{"status", o.Status, n.Status},
+		{"severity", o.Severity, n.Severity},
+	}), nil
+}
+
+func changedFields(fields []FieldChange) []FieldChange {
+	var changed []FieldChange
+	for _, f := range fields {
+		if f.Old != f.New {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
+
+func formatScore(score float64, valid bool) string {
+	if !valid {
+		return ""
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func joinVersions(versions []string) string {
+	return strings.Join(versions, " || ")
+}
+
+// WriteChanges writes changes to w in format.
+func WriteChanges(w io.Writer, changes []Change, format string) error {
+	switch format {
+	case DiffFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHANGE\tKIND\tVULNERABILITY\tPLATFORM\tSEGMENT\tPACKAGE\tFIELDS")
+		for _, c := range changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Change, c.Kind, c.VulnerabilityID, c.Platform, c.Segment, c.Package, formatFields(c.Fields))
+		}
+		return tw.Flush()
+	case DiffFormatJSON:
+		if changes == nil {
+			changes = []Change{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	case DiffFormatMarkdown:
+		counts := map[string]int{}
+		for _, c := range changes {
+			counts[c.Kind+" "+c.Change]++
+		}
+		fmt.Fprintln(w, "| | Added | Removed | Modified |")
+		fmt.Fprintln(w, "|---|---:|---:|---:|")
+		for _, k := range []string{kindVulnerability, kindAdvisory} {
+			fmt.Fprintf(w, "| %s | %d | %d | %d |\n", k, counts[k+" "+ChangeAdded], counts[k+" "+ChangeRemoved], counts[k+" "+ChangeModified])
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Change | Kind | Vulnerability | Platform | Segment | Package | Fields |")
+		fmt.Fprintln(w, "|---|---|---|---|---|---|---|")
+		for _, c := range changes {
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s |\n", c.Change, c.Kind, markdownCell(c.VulnerabilityID),
+				markdownCell(c.Platform), markdownCell(c.Segment), markdownCell(c.Package), markdownCell(formatFields(c.Fields)))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported diff format '%s'", format)
+	}
+}
+
+// formatFields returns fields as "field: old -> new" separated by commas. Empty values are written as "-".
+func formatFields(fields []FieldChange) string {
+	var s []string
+	for _, f := range fields {
+		s = append(s, fmt.Sprintf("%s: %s -> %s", f.Field, orDash(f.Old), orDash(f.New)))
+	}
+	return strings.Join(s, ", ")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+func markdownCell(s string) string {
+	return markdownEscaper.Replace(s)
+}
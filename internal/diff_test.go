@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeTrivyDB writes a trivy.db of vulns by vulnerability ID and advisories by bucket, package and vulnerability ID.
+func writeTrivyDB(t *testing.T, vulns map[string]string, advisories map[string]map[string]map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trivy.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		vb, err := tx.CreateBucket([]byte(vulnBucket))
+		if err != nil {
+			return err
+		}
+		for id, v := range vulns {
+			if err := vb.Put([]byte(id), []byte(v)); err != nil {
+				return err
+			}
+		}
+		for source, pkgs := range advisories {
+			sb, err := tx.CreateBucket([]byte(source))
+			if err != nil {
+				return err
+			}
+			for pkg, advs := range pkgs {
+				pb, err := sb.CreateBucket([]byte(pkg))
+				if err != nil {
+					return err
+				}
+				for id, v := range advs {
+					if err := pb.Put([]byte(id), []byte(v)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDiffDB(t *testing.T) {
+	oldPath := writeTrivyDB(t,
+		map[string]string{"CVE-2023-0001": `{"Severity":"MEDIUM"}`, "CVE-2023-0002": `{"Severity":"LOW"}`},
+		map[string]map[string]map[string]string{
+			"debian 12": {"openssl": {"CVE-2023-0001": `{"Status":2}`, "CVE-2023-0002": `{"Status":2}`}},
+		})
+	newPath := writeTrivyDB(t,
+		map[string]string{"CVE-2023-0001": `{"Severity":"CRITICAL"}`, "CVE-2023-0003": `{"Severity":"LOW"}`},
+		map[string]map[string]map[string]string{
+			"debian 12": {"openssl": {"CVE-2023-0001": `{"FixedVersion":"3.0.9-1"}`, "CVE-2023-0003": `{"Status":2}`}},
+		})
+	changes, err := DiffDB(context.Background(), oldPath, newPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"change":"modified","kind":"vulnerability","vulnerability_id":"CVE-2023-0001","fields":[{"field":"severity","old":"MEDIUM","new":"CRITICAL"}]},` +
+		`{"change":"removed","kind":"vulnerability","vulnerability_id":"CVE-2023-0002"},` +
+		`{"change":"added","kind":"vulnerability","vulnerability_id":"CVE-2023-0003"},` +
+		`{"change":"modified","kind":"advisory","vulnerability_id":"CVE-2023-0001","platform":"debian","segment":"12","package":"openssl",` +
+		`"fields":[{"field":"fixed_version","old":"","new":"3.0.9-1"},{"field":"status","old":"affected","new":"fixed"}]},` +
+		`{"change":"removed","kind":"advisory","vulnerability_id":"CVE-2023-0002","platform":"debian","segment":"12","package":"openssl"},` +
+		`{"change":"added","kind":"advisory","vulnerability_id":"CVE-2023-0003","platform":"debian","segment":"12","package":"openssl"}]`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
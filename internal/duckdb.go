@@ -0,0 +1,17 @@
+//go:build cgo
+
+package internal
+
+import (
+	"database/sql"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/k1LoW/trivy-db-to/drivers/duckdb"
+)
+
+// duckDBSupported reports whether this build can write DuckDB, which requires cgo.
+const duckDBSupported = true
+
+func newDuckDB(db *sql.DB, tableNames drivers.TableNames) (drivers.Driver, error) {
+	return duckdb.New(db, tableNames)
+}
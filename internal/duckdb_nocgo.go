@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package internal
+
+import (
+	"database/sql"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+// duckDBSupported reports whether this build can write DuckDB, which requires cgo.
+const duckDBSupported = false
+
+func newDuckDB(db *sql.DB, tableNames drivers.TableNames) (drivers.Driver, error) {
+	return nil, errDuckDBUnsupported
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/aquasecurity/trivy/pkg/log"
+	"github.com/k1LoW/trivy-db-to/drivers"
+	bolt "go.etcd.io/bbolt"
+)
+
+// keepHistory compares Trivy DB with the current rows of the history tables. Superseded and removed rows are closed
+// at validAt, and new and modified rows are inserted valid from validAt.
+func keepHistory(ctx context.Context, trivyDb *bolt.DB, keeper drivers.HistoryKeeper, sourceRe []*regexp.Regexp,
+	tableNames drivers.TableNames, validAt time.Time) error {
+	return trivyDb.View(func(tx *bolt.Tx) error {
+		log.Logger.Infof("Updating table '%s' ...", tableNames.VulnHistory)
+		hashes, err := keeper.VulnHistoryHashes(ctx)
+		if err != nil {
+			return err
+		}
+		d := historyDiffer(hashes, validAt, keeper.InsertVulnHistory, keeper.CloseVulnHistory)
+		if err := walkVulns(tx, func(vulns [][][]byte) error {
+			for _, vuln := range vulns {
+				if err := d.add(ctx, drivers.Key(string(vuln[0])), vuln, vuln[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := d.finish(ctx, tableNames.VulnHistory); err != nil {
+			return err
+		}
+
+		log.Logger.Infof("Updating table '%s' ...", tableNames.AdvisoryHistory)
+		hashes, err = keeper.VulnAdvisoryHistoryHashes(ctx)
+		if err != nil {
+			return err
+		}
+		d = historyDiffer(hashes, validAt, keeper.InsertVulnAdvisoryHistory, keeper.CloseVulnAdvisoryHistory)
+		if err := walkAdvisories(tx, sourceRe, func(secAdv [][][]byte) error {
+			for _, a := range secAdv {
+				key := drivers.Key(string(a[0]), string(a[1]), string(a[2]), string(a[3]))
+				if err := d.add(ctx, key, a, a[4]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		return d.finish(ctx, tableNames.AdvisoryHistory)
+	})
+}
+
+// historyValidAt returns UpdatedAt of the metadata of Trivy DB at dbPath, which the rows of a run are valid from.
+// It falls back to the time of the run if the metadata is missing.
+func historyValidAt(dbPath string) time.Time {
+	meta, err := readMetadata(filepath.Join(filepath.Dir(dbPath), metadataFile))
+	switch {
+	case err != nil:
+		log.Logger.Warnf("Failed to read Trivy DB metadata, so the history is kept at the time of the run: %s", err)
+	case meta.UpdatedAt.IsZero():
+		log.Logger.Warn("Trivy DB metadata has no UpdatedAt, so the history is kept at the time of the run")
+	default:
+		return meta.UpdatedAt.UTC().Truncate(time.Second)
+	}
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// historyDiffer returns a differ that inserts new rows, closes and reinserts modified rows, and closes removed rows.
+func historyDiffer(hashes map[string]string, validAt time.Time,
+	insert, closeRows func(ctx context.Context, at time.Time, rows [][][]byte) error) *differ {
+	d := &differ{hashes: hashes}
+	d.insert = func(ctx context.Context, rows [][][]byte) error {
+		return insert(ctx, validAt, rows)
+	}
+	d.update = func(ctx context.Context, rows [][][]byte) error {
+		if err := closeRows(ctx, validAt, rows); err != nil {
+			return err
+		}
+		return insert(ctx, validAt, rows)
+	}
+	d.delete = func(ctx context.Context, rows [][][]byte) error {
+		return closeRows(ctx, validAt, rows)
+	}
+	return d
+}
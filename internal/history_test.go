@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+	_ "modernc.org/sqlite"
+)
+
+func TestKeepHistory(t *testing.T) {
+	ctx := context.Background()
+	vulns := map[string]string{"CVE-2023-0001": `{"Severity":"HIGH"}`}
+	paths := []string{
+		writeTrivyDB(t, vulns, map[string]map[string]map[string]string{
+			"debian 12": {"openssl": {"CVE-2023-0001": `{"Status":3}`}, "curl": {"CVE-2023-0001": `{"Status":3}`}},
+		}),
+		writeTrivyDB(t, vulns, map[string]map[string]map[string]string{
+			"debian 12": {"openssl": {"CVE-2023-0001": `{"FixedVersion":"3.0.9-1","Status":2}`}},
+		}),
+	}
+	tableNames := drivers.TableNames{
+		Vulnerabilities: "vulnerabilities",
+		Advisories:      "vulnerability_advisories",
+		DataSource:      "data_source",
+		Metadata:        "metadata",
+		VulnHistory:     "vulnerabilities_history",
+		AdvisoryHistory: "vulnerability_advisories_history",
+		CVSS:            "vulnerability_cvss",
+		References:      "vulnerability_references",
+		CWEs:            "vulnerability_cwes",
+	}
+	target := filepath.Join(t.TempDir(), "target.db")
+	dsn := "sqlite://" + target
+	if err := InitDB(ctx, dsn, tableNames); err != nil {
+		t.Fatal(err)
+	}
+	updatedAt := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, path := range paths {
+		// the rows are valid from UpdatedAt of the metadata of Trivy DB
+		meta := fmt.Sprintf(`{"Version":2,"UpdatedAt":%q}`, updatedAt.AddDate(0, 0, i).Format(time.RFC3339))
+		if err := os.WriteFile(filepath.Join(filepath.Dir(path), metadataFile), []byte(meta), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := UpdateDB(ctx, path, dsn, tableNames, nil, LoadModeSwap, drivers.Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, tt := range []struct {
+		asOf string
+		want []string
+	}{
+		{"2023-05-31", nil},
+		{"2023-06-01 12:00:00", []string{"curl ", "openssl "}},
+		{"2023-06-02 12:00:00", []string{"openssl 3.0.9-1"}},
+	} {
+		rows, err := db.Query(`SELECT package, fixed_version FROM vulnerability_advisories_history
+WHERE valid_from <= $1 AND (valid_to IS NULL OR valid_to > $1) ORDER BY package`, tt.asOf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var pkg, fixed string
+			if err := rows.Scan(&pkg, &fixed); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, pkg+" "+fixed)
+		}
+		_ = rows.Close()
+		if len(got) != len(tt.want) {
+			t.Errorf("as of %s got %q, want %q", tt.asOf, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("as of %s got %q, want %q", tt.asOf, got, tt.want)
+			}
+		}
+	}
+	// the vulnerability is unchanged, so it has a single row
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM vulnerabilities_history").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("got %d rows of vulnerabilities_history, want 1", count)
+	}
+}
@@ -3,22 +3,32 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aquasecurity/trivy/pkg/log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	db2 "github.com/aquasecurity/trivy-db/pkg/db"
+	"github.com/aquasecurity/trivy-db/pkg/metadata"
 	"github.com/aquasecurity/trivy/pkg/db"
 	"github.com/aquasecurity/trivy/pkg/fanal/types"
 	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/k1LoW/trivy-db-to/drivers/csv"
+	"github.com/k1LoW/trivy-db-to/drivers/dump"
+	"github.com/k1LoW/trivy-db-to/drivers/elasticsearch"
+	"github.com/k1LoW/trivy-db-to/drivers/jsonl"
 	"github.com/k1LoW/trivy-db-to/drivers/mysql"
+	"github.com/k1LoW/trivy-db-to/drivers/osv"
+	"github.com/k1LoW/trivy-db-to/drivers/parquet"
 	"github.com/k1LoW/trivy-db-to/drivers/postgres"
 	"github.com/k1LoW/trivy-db-to/drivers/sqlite"
-	"github.com/samber/lo"
+	"github.com/k1LoW/trivy-db-to/version"
 	"github.com/xo/dburl"
 	bolt "go.etcd.io/bbolt"
 )
@@ -27,28 +37,46 @@ const (
 	chunkSize        = 5000
 	vulnBucket       = "vulnerability"
 	dataSourceBucket = "data-source"
+	trivyDBFile      = "trivy.db"
+	metadataFile     = "metadata.json"
 	appVersion       = "99.9.9"
-	dbRepository     = "ghcr.io/aquasecurity/trivy-db"
+	// DefaultDBRepository is the repository of Trivy DB used when no repository is configured.
+	DefaultDBRepository = "ghcr.io/aquasecurity/trivy-db"
 )
 
-func FetchTrivyDB(ctx context.Context, cacheDir string, light, quiet, skipUpdate bool) error {
+const (
+	// LoadModeSwap loads all rows into shadow tables and swaps them in.
+	LoadModeSwap = "swap"
+	// LoadModeIncremental writes only the rows that changed since the last load.
+	LoadModeIncremental = "incremental"
+)
+
+// FetchTrivyDB downloads Trivy DB into cacheDir if it needs update.
+// The repositories of opts are tried in order until a download succeeds.
+func FetchTrivyDB(ctx context.Context, cacheDir string, light, quiet, skipUpdate bool, opts RegistryOptions) error {
 	log.Logger.Info("Fetching and updating Trivy DB ... ")
 	dbPath := db2.Path(cacheDir)
 	dbDir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dbDir, 0700); err != nil {
 		return err
 	}
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		repos = []string{DefaultDBRepository}
+	}
 
-	client := db.NewClient(cacheDir, quiet, db.WithDBRepository(dbRepository))
+	client := db.NewClient(cacheDir, quiet, db.WithDBRepository(repos[0]))
 	needsUpdate, err := client.NeedsUpdate(appVersion, skipUpdate)
 	if err != nil {
 		return fmt.Errorf("database error: %w", err)
 	}
 
 	if needsUpdate {
-		log.Logger.Infof("Need to update DB, and DB Repository is %s", dbRepository)
-		log.Logger.Info("Downloading DB...")
-		if err = client.Download(ctx, cacheDir, types.RemoteOptions{}); err != nil {
+		if err := tryRepositories(repos, func(repo string) error {
+			log.Logger.Infof("Need to update DB, and DB Repository is %s", repo)
+			log.Logger.Info("Downloading DB...")
+			return downloadTrivyDB(ctx, cacheDir, repo, quiet, opts)
+		}); err != nil {
 			return fmt.Errorf("failed to download vulnerability DB: %w", err)
 		}
 	}
@@ -57,37 +85,22 @@ func FetchTrivyDB(ctx context.Context, cacheDir string, light, quiet, skipUpdate
 	return nil
 }
 
-func InitDB(ctx context.Context, dsn, vulnerabilityTableName, advisoryTableName string,
-	dataSourceTableName string) error {
-	var (
-		driver drivers.Driver
-		err    error
-	)
-	log.Logger.Info("Initializing vulnerability information tables ...")
-	db, d, err := dbOpen(dsn)
+func downloadTrivyDB(ctx context.Context, cacheDir, repo string, quiet bool, opts RegistryOptions) error {
+	art, err := newArtifact(ctx, repositoryRef(repo, db2.SchemaVersion), quiet, opts)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	switch d {
-	case "mysql":
-		driver, err = mysql.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
-		}
-	case "postgres":
-		driver, err = postgres.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
-		}
-	case "sqlite":
-		driver, err = sqlite.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("unsupported driver '%s'", d)
+	client := db.NewClient(cacheDir, quiet, db.WithDBRepository(repo), db.WithOCIArtifact(art))
+	return client.Download(ctx, cacheDir, types.RemoteOptions{Insecure: opts.Insecure})
+}
+
+func InitDB(ctx context.Context, dsn string, tableNames drivers.TableNames) error {
+	log.Logger.Info("Initializing vulnerability information tables ...")
+	driver, _, closeDriver, err := openDriver(dsn, tableNames, drivers.Options{})
+	if err != nil {
+		return err
 	}
+	defer closeDriver()
 
 	if err := driver.Migrate(ctx); err != nil {
 		return err
@@ -96,130 +109,194 @@ func InitDB(ctx context.Context, dsn, vulnerabilityTableName, advisoryTableName
 	return nil
 }
 
-func UpdateDB(ctx context.Context, cacheDir, dsn, vulnerabilityTableName, advisoryTableName string,
-	targetSources []string, dataSourceTableName string) error {
+// TrivyDBPath returns the path of trivy.db fetched into cacheDir.
+func TrivyDBPath(cacheDir string) string {
+	return db2.Path(cacheDir)
+}
+
+// UpdateDB loads Trivy DB at dbPath into the datasource. metadata.json is read from the directory of dbPath if exists.
+func UpdateDB(ctx context.Context, dbPath, dsn string, tableNames drivers.TableNames,
+	targetSources []string, loadMode string, opts drivers.Options) error {
 	log.Logger.Info("Updating vulnerability information tables ...")
-	var (
-		driver drivers.Driver
-		err    error
-	)
+	driver, d, closeDriver, err := openDriver(dsn, tableNames, opts)
+	if err != nil {
+		return err
+	}
+	defer closeDriver()
 
-	db, d, err := dbOpen(dsn)
+	sourceRe, err := compileSources(targetSources)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	switch d {
-	case "mysql":
-		driver, err = mysql.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
+
+	trivyDb, err := openTrivyDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer trivyDb.Close()
+
+	if opts.SQLiteCompact && loadMode != LoadModeSwap {
+		return fmt.Errorf("load mode '%s' is not supported for a compact SQLite database", loadMode)
+	}
+
+	var keeper drivers.HistoryKeeper
+	if tableNames.VulnHistory != "" || tableNames.AdvisoryHistory != "" {
+		var ok bool
+		keeper, ok = driver.(drivers.HistoryKeeper)
+		if !ok {
+			return fmt.Errorf("driver '%s' does not support keeping history", d)
 		}
-	case "postgres":
-		driver, err = postgres.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
+	}
+
+	var changes *changeLog
+	if tableNames.Changes != "" {
+		recorder, ok := driver.(drivers.ChangeRecorder)
+		if !ok {
+			return fmt.Errorf("driver '%s' does not support recording changes", d)
 		}
-	case "sqlite":
-		driver, err = sqlite.New(db, vulnerabilityTableName, advisoryTableName, dataSourceTableName)
-		if err != nil {
-			return err
+		changes = newChangeLog(recorder)
+	}
+
+	var counts map[string]int
+	switch loadMode {
+	case LoadModeSwap:
+		counts, err = swapTables(ctx, trivyDb, driver, sourceRe, tableNames, changes)
+	case LoadModeIncremental:
+		syncer, ok := driver.(drivers.Syncer)
+		if !ok {
+			return fmt.Errorf("driver '%s' does not support load mode '%s'", d, loadMode)
 		}
+		counts, err = syncTables(ctx, trivyDb, syncer, sourceRe, tableNames, changes)
 	default:
-		return fmt.Errorf("unsupported driver '%s'", d)
+		return fmt.Errorf("unsupported load mode '%s'", loadMode)
 	}
-
-	trivyDb, err := bolt.Open(filepath.Join(cacheDir, "db", "trivy.db"), 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return err
 	}
-	defer trivyDb.Close()
 
-	if err := trivyDb.View(func(tx *bolt.Tx) error {
-		log.Logger.Infof("Updating table '%s' ...", vulnerabilityTableName)
-		if err := driver.TruncateVulns(ctx); err != nil {
+	if keeper != nil {
+		if err := keepHistory(ctx, trivyDb, keeper, sourceRe, tableNames, historyValidAt(dbPath)); err != nil {
 			return err
 		}
-		b := tx.Bucket([]byte(vulnBucket))
-		c := b.Cursor()
-		started := false
-		ended := false
-		for {
-			var vulns [][][]byte
-			if !started {
-				k, v := c.First()
-				vulns = append(vulns, [][]byte{k, v})
-				started = true
+	}
+
+	log.Logger.Infof("Updating table '%s' ...", tableNames.Metadata)
+	if err := driver.InsertMetadata(ctx, newMetadata(dbPath, counts)); err != nil {
+		return err
+	}
+	log.Logger.Info("done")
+	return nil
+}
+
+// openTrivyDB opens Trivy DB at path read-only.
+func openTrivyDB(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+}
+
+// compileSources compiles the patterns of the advisory buckets to load.
+func compileSources(targetSources []string) ([]*regexp.Regexp, error) {
+	var sourceRe []*regexp.Regexp
+	for _, s := range targetSources {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		sourceRe = append(sourceRe, re)
+	}
+	return sourceRe, nil
+}
+
+// newMetadata returns the metadata of Trivy DB at dbPath and the row counts of the run.
+func newMetadata(dbPath string, counts map[string]int) drivers.Metadata {
+	m := drivers.Metadata{
+		TrivyDBToVersion: version.Version,
+		RowCounts:        counts,
+	}
+	meta, err := readMetadata(filepath.Join(filepath.Dir(dbPath), metadataFile))
+	if err != nil {
+		log.Logger.Warnf("Failed to read Trivy DB metadata: %s", err)
+		return m
+	}
+	m.Version = meta.Version
+	m.UpdatedAt = meta.UpdatedAt
+	m.NextUpdate = meta.NextUpdate
+	m.DownloadedAt = meta.DownloadedAt
+	return m
+}
+
+func readMetadata(path string) (metadata.Metadata, error) {
+	var meta metadata.Metadata
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// swapTables loads all rows into shadow tables and swaps them in. It returns the row counts per table.
+// If changes is not nil, the advisories are compared with the ones of the tables being replaced to record the changes
+// once the swap succeeds.
+func swapTables(ctx context.Context, trivyDb *bolt.DB, driver drivers.Driver, sourceRe []*regexp.Regexp,
+	tableNames drivers.TableNames, changes *changeLog) (map[string]int, error) {
+	var d *differ
+	if changes != nil {
+		hashes, err := changes.recorder.VulnAdvisoryHashes(ctx)
+		if err != nil {
+			return nil, err
+		}
+		d = changes.swapDiffer(hashes)
+	}
+	if err := driver.CreateShadowTables(ctx); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	if err := trivyDb.View(func(tx *bolt.Tx) error {
+		log.Logger.Infof("Updating table '%s' ...", tableNames.Vulnerabilities)
+		children := vulnChildren(driver, tableNames)
+		if err := walkVulns(tx, func(vulns [][][]byte) error {
+			counts[tableNames.Vulnerabilities] += len(vulns)
+			if err := driver.InsertVuln(ctx, vulns); err != nil {
+				return err
 			}
-			for i := 0; i < chunkSize; i++ {
-				k, v := c.Next()
-				if k == nil {
-					ended = true
-					break
+			for _, c := range children {
+				rows, err := c.rows(vulns)
+				if err != nil {
+					return err
 				}
-				vulns = append(vulns, [][]byte{k, v})
-			}
-			if len(vulns) > 0 {
-				if err := driver.InsertVuln(ctx, vulns); err != nil {
+				counts[c.table] += len(rows)
+				if err := c.insert(ctx, rows); err != nil {
 					return err
 				}
 			}
-			if ended {
-				break
-			}
+			return nil
+		}); err != nil {
+			return err
 		}
 
-		if err = updateDataSource(dataSourceBucket, driver, ctx, tx); err != nil {
-			log.Logger.Fatalf("Failed to update data-source table:%s", err.Error())
+		log.Logger.Infof("Updating table '%s' ...", tableNames.DataSource)
+		if err := walkDataSources(tx, func(dataSources [][][]byte) error {
+			counts[tableNames.DataSource] += len(dataSources)
+			return driver.InsertDataSource(ctx, dataSources)
+		}); err != nil {
+			return err
 		}
 
-		var sourceRe []*regexp.Regexp
-		for _, s := range targetSources {
-			re, err := regexp.Compile(s)
-			if err != nil {
+		log.Logger.Infof("Updating table '%s' ...", tableNames.Advisories)
+		if err := walkAdvisories(tx, sourceRe, func(secAdv [][][]byte) error {
+			counts[tableNames.Advisories] += len(secAdv)
+			if err := driver.InsertVulnAdvisory(ctx, secAdv); err != nil {
 				return err
 			}
-			sourceRe = append(sourceRe, re)
-		}
-		log.Logger.Infof("Updating table '%s' ...", advisoryTableName)
-		if err := driver.TruncateVulnAdvisories(ctx); err != nil {
-			return err
-		}
-		if err := tx.ForEach(func(source []byte, b *bolt.Bucket) error {
-			var s = string(source)
-			if s == vulnBucket {
+			if d == nil {
 				return nil
 			}
-
-			if len(sourceRe) > 0 {
-				found := false
-				for _, re := range sourceRe {
-					if re.MatchString(s) {
-						found = true
-						break
-					}
-				}
-				if !found {
-					return nil
-				}
-			}
-			log.Logger.Infof("Writing security advisory: %s ...", s)
-			c := b.Cursor()
-			var secAdv [][][]byte
-			for pkg, _ := c.First(); pkg != nil; pkg, _ = c.Next() {
-				cb := b.Bucket(pkg)
-				if cb == nil {
-					continue
-				}
-				cbc := cb.Cursor()
-				for vID, v := cbc.First(); vID != nil; vID, v = cbc.Next() {
-					platform, segment := parsePlatformAndSegment(s)
-					secAdv = append(secAdv, [][]byte{vID, platform, segment, pkg, v})
-				}
-			}
-			chunked := lo.Chunk(secAdv, chunkSize)
-			for _, c := range chunked {
-				if err := driver.InsertVulnAdvisory(ctx, c); err != nil {
+			for _, a := range secAdv {
+				key := drivers.Key(string(a[0]), string(a[1]), string(a[2]), string(a[3]))
+				if err := d.add(ctx, key, a, a[4]); err != nil {
 					return err
 				}
 			}
@@ -227,15 +304,271 @@ func UpdateDB(ctx context.Context, cacheDir, dsn, vulnerabilityTableName, adviso
 		}); err != nil {
 			return err
 		}
+		if d == nil {
+			return nil
+		}
 
-		return nil
+		log.Logger.Infof("Updating table '%s' ...", tableNames.Changes)
+		if err := d.finish(ctx, tableNames.Advisories); err != nil {
+			return err
+		}
+		return changes.flush(ctx, 0)
 	}); err != nil {
-		return err
+		dropShadowTables(ctx, driver)
+		return nil, err
+	}
+
+	log.Logger.Info("Swapping tables ...")
+	if err := driver.SwapTables(ctx); err != nil {
+		dropShadowTables(ctx, driver)
+		return nil, err
+	}
+	return counts, nil
+}
+
+// vulnChild is a table whose rows are derived from the values of the vulnerability bucket.
+type vulnChild struct {
+	table  string
+	rows   func(vulns [][][]byte) ([][][]byte, error)
+	insert func(ctx context.Context, rows [][][]byte) error
+	// delete deletes the rows of vulnerabilities. It is used by incremental loads only.
+	delete func(ctx context.Context, vulns [][][]byte) error
+}
+
+func vulnChildren(driver drivers.Driver, tableNames drivers.TableNames) []vulnChild {
+	return []vulnChild{
+		{table: tableNames.CVSS, rows: drivers.CVSSRows, insert: driver.InsertVulnCVSS},
+		{table: tableNames.References, rows: drivers.ReferenceRows, insert: driver.InsertVulnReferences},
+		{table: tableNames.CWEs, rows: drivers.CWERows, insert: driver.InsertVulnCWEs},
+	}
+}
+
+func dropShadowTables(ctx context.Context, driver drivers.Driver) {
+	if err := driver.DropShadowTables(ctx); err != nil {
+		log.Logger.Errorf("Failed to drop shadow tables: %s", err)
+	}
+}
+
+// walkVulns calls fn with chunks of [vulnerability_id, value] in the vulnerability bucket.
+func walkVulns(tx *bolt.Tx, fn func(vulns [][][]byte) error) error {
+	return walkBucket(tx.Bucket([]byte(vulnBucket)), fn)
+}
+
+// walkDataSources calls fn with chunks of [source_key, value] in the data-source bucket.
+func walkDataSources(tx *bolt.Tx, fn func(dataSources [][][]byte) error) error {
+	return walkBucket(tx.Bucket([]byte(dataSourceBucket)), fn)
+}
+
+func walkBucket(b *bolt.Bucket, fn func(rows [][][]byte) error) error {
+	if b == nil {
+		return nil
+	}
+	var rows [][][]byte
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		rows = append(rows, [][]byte{k, v})
+		if len(rows) == chunkSize {
+			if err := fn(rows); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if len(rows) > 0 {
+		return fn(rows)
 	}
-	log.Logger.Info("done")
 	return nil
 }
 
+// walkAdvisories calls fn with chunks of [vulnerability_id, platform, segment, package, value]
+// in the advisory buckets matching sourceRe.
+func walkAdvisories(tx *bolt.Tx, sourceRe []*regexp.Regexp, fn func(secAdv [][][]byte) error) error {
+	return tx.ForEach(func(source []byte, b *bolt.Bucket) error {
+		var s = string(source)
+		if s == vulnBucket || s == dataSourceBucket {
+			return nil
+		}
+
+		if len(sourceRe) > 0 {
+			found := false
+			for _, re := range sourceRe {
+				if re.MatchString(s) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil
+			}
+		}
+		log.Logger.Infof("Writing security advisory: %s ...", s)
+		platform, segment := parsePlatformAndSegment(s)
+		c := b.Cursor()
+		var secAdv [][][]byte
+		for pkg, _ := c.First(); pkg != nil; pkg, _ = c.Next() {
+			cb := b.Bucket(pkg)
+			if cb == nil {
+				continue
+			}
+			cbc := cb.Cursor()
+			for vID, v := cbc.First(); vID != nil; vID, v = cbc.Next() {
+				secAdv = append(secAdv, [][]byte{vID, platform, segment, pkg, v})
+				if len(secAdv) == chunkSize {
+					if err := fn(secAdv); err != nil {
+						return err
+					}
+					secAdv = nil
+				}
+			}
+		}
+		if len(secAdv) > 0 {
+			return fn(secAdv)
+		}
+		return nil
+	})
+}
+
+func newDriver(db *sql.DB, d string, tableNames drivers.TableNames, opts drivers.Options) (drivers.Driver, error) {
+	switch d {
+	case "mysql":
+		return mysql.New(db, tableNames, opts)
+	case "postgres":
+		return postgres.New(db, tableNames)
+	case "sqlite":
+		return sqlite.New(db, tableNames, opts)
+	case "duckdb":
+		return newDuckDB(db, tableNames)
+	default:
+		return nil, fmt.Errorf("unsupported driver '%s'", d)
+	}
+}
+
+var errDuckDBUnsupported = errors.New("DuckDB is not supported by this build of trivy-db-to, which was built without cgo")
+
+func init() {
+	// duckdb:///path/to/file.duckdb
+	dburl.Register(dburl.Scheme{Driver: "duckdb", Generator: dburl.GenOpaque, Opaque: true})
+}
+
+// openDriver returns the driver of dsn, its name and a function closing the datasource.
+func openDriver(dsn string, tableNames drivers.TableNames, opts drivers.Options) (drivers.Driver, string, func() error, error) {
+	if dialect, path, ok := dumpDSN(dsn); ok {
+		return openDump(dialect, path, tableNames, opts)
+	}
+	if d, path, ok := fileDSN(dsn); ok {
+		driver, err := newFileDriver(d, path, tableNames, opts)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return driver, d, func() error { return nil }, nil
+	}
+	db, d, err := dbOpen(dsn)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	driver, err := newDriver(db, d, tableNames, opts)
+	if err != nil {
+		_ = db.Close()
+		return nil, "", nil, err
+	}
+	return driver, d, db.Close, nil
+}
+
+const dumpSchemePrefix = "dump+"
+
+// dumpDSN returns the dialect and the path of a DSN of a SQL dump such as dump+mysql:///path/to/file.sql.
+func dumpDSN(dsn string) (string, string, bool) {
+	scheme, path, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return "", "", false
+	}
+	dialect, ok := strings.CutPrefix(scheme, dumpSchemePrefix)
+	if !ok {
+		return "", "", false
+	}
+	switch dialect {
+	case "postgresql", "pg":
+		dialect = dump.Postgres
+	case "sqlite3":
+		dialect = dump.SQLite
+	}
+	return dialect, path, true
+}
+
+// openDump returns the driver of dialect writing the statements of a live run into the SQL script at path.
+func openDump(dialect, path string, tableNames drivers.TableNames, opts drivers.Options) (drivers.Driver, string, func() error, error) {
+	db, err := dump.Open(path, dialect)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// LOAD DATA LOCAL INFILE streams rows from the client, which a script cannot do
+	opts.MySQLLocalInfile = false
+	driver, err := newDriver(db, dialect, tableNames, opts)
+	if err != nil {
+		_ = db.Close()
+		return nil, "", nil, err
+	}
+	if loader, ok := driver.(drivers.JavaDBLoader); ok {
+		return dumpJavaDBDriver{loader}, dumpSchemePrefix + dialect, db.Close, nil
+	}
+	return dumpDriver{driver}, dumpSchemePrefix + dialect, db.Close, nil
+}
+
+// dumpDriver hides drivers.Syncer of the drivers writing SQL dumps, because a script cannot read the hashes of the rows to diff.
+type dumpDriver struct {
+	drivers.Driver
+}
+
+// dumpJavaDBDriver is a dumpDriver that keeps drivers.JavaDBLoader of the driver.
+type dumpJavaDBDriver struct {
+	drivers.JavaDBLoader
+}
+
+// fileSchemes are the schemes of the DSNs of drivers writing files instead of databases.
+var fileSchemes = []string{"parquet", "jsonl", "csv", "elasticsearch", "osv"}
+
+// jsonlExts are the extensions of the file:// DSNs written as JSON Lines. Other file:// DSNs are opened by dburl.
+var jsonlExts = []string{".jsonl", ".ndjson"}
+
+// fileDSN returns the driver and the path of a DSN of fileSchemes.
+func fileDSN(dsn string) (string, string, bool) {
+	scheme, path, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return "", "", false
+	}
+	if scheme == "file" && slices.Contains(jsonlExts, filepath.Ext(path)) {
+		return "jsonl", path, true
+	}
+	for _, s := range fileSchemes {
+		if scheme == s {
+			return s, path, true
+		}
+	}
+	return "", "", false
+}
+
+func newFileDriver(d, path string, tableNames drivers.TableNames, opts drivers.Options) (drivers.Driver, error) {
+	switch d {
+	case "parquet":
+		return parquet.New(path, tableNames, opts)
+	case "csv":
+		return csv.New(path, tableNames, opts)
+	case "elasticsearch":
+		return elasticsearch.New(path, tableNames, opts)
+	case "osv":
+		// osv:///path/to/dir writes a document per file, osv:///path/to/all.zip writes a zip archive
+		return osv.New(path)
+	case "jsonl":
+		// jsonl://- and jsonl:///path/to/file.jsonl write a single stream, jsonl:///path/to/dir writes a file per table
+		if path == "-" || slices.Contains(jsonlExts, filepath.Ext(path)) {
+			return jsonl.NewStream(path, tableNames)
+		}
+		return jsonl.New(path, tableNames)
+	default:
+		return nil, fmt.Errorf("unsupported driver '%s'", d)
+	}
+}
+
 func dbOpen(dsn string) (*sql.DB, string, error) {
 	u, err := dburl.Parse(dsn)
 	if err != nil {
@@ -244,6 +577,9 @@ func dbOpen(dsn string) (*sql.DB, string, error) {
 	if u.Driver == "sqlite3" {
 		u.Driver = "sqlite"
 	}
+	if u.Driver == "duckdb" && !duckDBSupported {
+		return nil, "", errDuckDBUnsupported
+	}
 	db, err := sql.Open(u.Driver, u.DSN)
 	if err != nil {
 		return nil, "", err
@@ -267,38 +603,3 @@ func parsePlatformAndSegment(s string) ([]byte, []byte) {
 	}
 	return platform, segment
 }
-func updateDataSource(dataSourceTableName string, driver drivers.Driver, ctx context.Context, tx *bolt.Tx) error {
-	log.Logger.Infof("Updating table '%s' ...", dataSourceTableName)
-	if err := driver.TruncateDataSource(ctx); err != nil {
-		return err
-	}
-	b := tx.Bucket([]byte(dataSourceBucket))
-	c := b.Cursor()
-	started := false
-	ended := false
-	for {
-		var dataSource [][][]byte
-		if !started {
-			k, v := c.First()
-			dataSource = append(dataSource, [][]byte{k, v})
-			started = true
-		}
-		for i := 0; i < chunkSize; i++ {
-			k, v := c.Next()
-			if k == nil {
-				ended = true
-				break
-			}
-			dataSource = append(dataSource, [][]byte{k, v})
-		}
-		if len(dataSource) > 0 {
-			if err := driver.InsertDataSource(ctx, dataSource); err != nil {
-				return err
-			}
-		}
-		if ended {
-			break
-		}
-	}
-	return nil
-}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	javadb "github.com/aquasecurity/trivy-java-db/pkg/db"
+	"github.com/aquasecurity/trivy/pkg/log"
+	"github.com/aquasecurity/trivy/pkg/oci"
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const (
+	// DefaultJavaDBRepository is the repository of Trivy Java DB used when no repository is configured.
+	DefaultJavaDBRepository = "ghcr.io/aquasecurity/trivy-java-db"
+	javaDBDir               = "java-db"
+	javaDBFile              = "trivy-java.db"
+	javaDBMediaType         = "application/vnd.aquasec.trivy.javadb.layer.v1.tar+gzip"
+)
+
+// FetchJavaDB downloads Trivy Java DB into cacheDir if it is missing or expired.
+// The repositories of opts are tried in order until a download succeeds.
+func FetchJavaDB(ctx context.Context, cacheDir string, quiet, skipUpdate bool, opts RegistryOptions) error {
+	log.Logger.Info("Fetching and updating Trivy Java DB ... ")
+	dir := filepath.Join(cacheDir, javaDBDir)
+	repos := opts.Repositories
+	if len(repos) == 0 {
+		repos = []string{DefaultJavaDBRepository}
+	}
+
+	metac := javadb.NewMetadata(dir)
+	meta, err := metac.Get()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read Java DB metadata: %w", err)
+		}
+		if skipUpdate {
+			return errors.New("Trivy Java DB is not found, so updating it cannot be skipped")
+		}
+	}
+	if skipUpdate || (meta.Version == javadb.SchemaVersion && meta.NextUpdate.After(time.Now().UTC())) {
+		log.Logger.Info("done")
+		return nil
+	}
+
+	if err := tryRepositories(repos, func(repo string) error {
+		log.Logger.Infof("Java DB Repository is %s", repo)
+		log.Logger.Info("Downloading Java DB...")
+		art, err := newArtifact(ctx, repositoryRef(repo, javadb.SchemaVersion), quiet, opts)
+		if err != nil {
+			return err
+		}
+		return art.Download(ctx, dir, oci.DownloadOption{MediaType: javaDBMediaType})
+	}); err != nil {
+		return fmt.Errorf("failed to download Java DB: %w", err)
+	}
+
+	meta, err = metac.Get()
+	if err != nil {
+		return fmt.Errorf("failed to read Java DB metadata: %w", err)
+	}
+	meta.DownloadedAt = time.Now().UTC()
+	if err := metac.Update(meta); err != nil {
+		return err
+	}
+	log.Logger.Info("done")
+	return nil
+}
+
+// JavaDBPath returns the path of trivy-java.db fetched into cacheDir.
+func JavaDBPath(cacheDir string) string {
+	return filepath.Join(cacheDir, javaDBDir, javaDBFile)
+}
+
+func InitJavaDB(ctx context.Context, dsn string, tableNames drivers.TableNames) error {
+	log.Logger.Info("Initializing Java DB tables ...")
+	driver, d, closeDriver, err := openDriver(dsn, tableNames, drivers.Options{})
+	if err != nil {
+		return err
+	}
+	defer closeDriver()
+	loader, ok := driver.(drivers.JavaDBLoader)
+	if !ok {
+		return fmt.Errorf("driver '%s' does not support Trivy Java DB", d)
+	}
+
+	if err := loader.MigrateJavaDB(ctx); err != nil {
+		return err
+	}
+	log.Logger.Info("done")
+	return nil
+}
+
+// UpdateJavaDB loads Trivy Java DB at javaDBPath into the datasource.
+// The tables are always replaced as a whole, because Trivy Java DB has no stable keys to diff.
+func UpdateJavaDB(ctx context.Context, javaDBPath, dsn string, tableNames drivers.TableNames, opts drivers.Options) error {
+	log.Logger.Info("Updating Java DB tables ...")
+	driver, d, closeDriver, err := openDriver(dsn, tableNames, opts)
+	if err != nil {
+		return err
+	}
+	defer closeDriver()
+	loader, ok := driver.(drivers.JavaDBLoader)
+	if !ok {
+		return fmt.Errorf("driver '%s' does not support Trivy Java DB", d)
+	}
+
+	if _, err := os.Stat(javaDBPath); err != nil {
+		return err
+	}
+	javaDb, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro", javaDBPath))
+	if err != nil {
+		return err
+	}
+	defer javaDb.Close()
+
+	if err := loader.CreateJavaDBShadowTables(ctx); err != nil {
+		return err
+	}
+	tables := []struct {
+		name   string
+		query  string
+		insert func(ctx context.Context, rows [][][]byte) error
+	}{
+		{tableNames.JavaArtifacts, "SELECT id, group_id, artifact_id FROM artifacts ORDER BY id", loader.InsertJavaArtifacts},
+		// sha1 is stored as a BLOB in Trivy Java DB
+		{tableNames.JavaIndices, "SELECT artifact_id, COALESCE(version, ''), lower(hex(sha1)), COALESCE(archive_type, '') FROM indices WHERE artifact_id IS NOT NULL ORDER BY rowid", loader.InsertJavaIndices},
+	}
+	for _, t := range tables {
+		log.Logger.Infof("Updating table '%s' ...", t.name)
+		count := 0
+		if err := walkRows(ctx, javaDb, t.query, func(rows [][][]byte) error {
+			count += len(rows)
+			return t.insert(ctx, rows)
+		}); err != nil {
+			dropJavaDBShadowTables(ctx, loader)
+			return err
+		}
+		log.Logger.Infof("Table '%s': %d rows", t.name, count)
+	}
+
+	log.Logger.Info("Swapping tables ...")
+	if err := loader.SwapJavaDBTables(ctx); err != nil {
+		dropJavaDBShadowTables(ctx, loader)
+		return err
+	}
+	log.Logger.Info("done")
+	return nil
+}
+
+func dropJavaDBShadowTables(ctx context.Context, loader drivers.JavaDBLoader) {
+	if err := loader.DropJavaDBShadowTables(ctx); err != nil {
+		log.Logger.Errorf("Failed to drop shadow tables: %s", err)
+	}
+}
+
+// walkRows calls fn with chunks of the rows of query. Each column is read as bytes.
+func walkRows(ctx context.Context, db *sql.DB, query string, fn func(rows [][][]byte) error) error {
+	rs, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+	columns, err := rs.Columns()
+	if err != nil {
+		return err
+	}
+	var rows [][][]byte
+	for rs.Next() {
+		row := make([][]byte, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rs.Scan(dest...); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+		if len(rows) == chunkSize {
+			if err := fn(rows); err != nil {
+				return err
+			}
+			rows = nil
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return fn(rows)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	javadb "github.com/aquasecurity/trivy-java-db/pkg/db"
+	"github.com/aquasecurity/trivy-java-db/pkg/types"
+	"github.com/k1LoW/trivy-db-to/drivers"
+	_ "modernc.org/sqlite"
+)
+
+func TestUpdateJavaDB(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	jdb, err := javadb.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jdb.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := jdb.InsertIndexes([]types.Index{
+		{GroupID: "org.apache.logging.log4j", ArtifactID: "log4j-core", Version: "2.14.1", SHA1: []byte{0x9a, 0x1b}, ArchiveType: types.JarType},
+		{GroupID: "org.apache.logging.log4j", ArtifactID: "log4j-core", Version: "2.17.0", SHA1: []byte{0x01, 0xff}, ArchiveType: types.JarType},
+		{GroupID: "com.google.guava", ArtifactID: "guava", Version: "31.0", SHA1: []byte{0xab, 0xcd}, ArchiveType: types.JarType},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "target.db")
+	dsn := "sqlite://" + target
+	tableNames := drivers.TableNames{JavaArtifacts: "java_artifacts", JavaIndices: "java_indices"}
+	if err := InitJavaDB(ctx, dsn, tableNames); err != nil {
+		t.Fatal(err)
+	}
+	// loading twice replaces the rows
+	for i := 0; i < 2; i++ {
+		if err := UpdateJavaDB(ctx, filepath.Join(dir, javaDBFile), dsn, tableNames, drivers.Options{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM java_indices").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got %d indices, want 3", count)
+	}
+	var groupID, artifactID, version string
+	if err := db.QueryRow(`SELECT a.group_id, a.artifact_id, i.version FROM java_indices i
+JOIN java_artifacts a ON a.id = i.artifact_id WHERE i.sha1 = '01ff'`).Scan(&groupID, &artifactID, &version); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := groupID+":"+artifactID+":"+version, "org.apache.logging.log4j:log4j-core:2.17.0"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
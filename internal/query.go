@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+const (
+	// QueryFormatTable writes advisories as a table of aligned columns.
+	QueryFormatTable = "table"
+	// QueryFormatJSON writes advisories as a JSON array.
+	QueryFormatJSON = "json"
+)
+
+// AdvisoryFilter selects advisories by the columns that are not empty.
+type AdvisoryFilter struct {
+	Platform        string
+	Segment         string
+	Package         string
+	VulnerabilityID string
+}
+
+// Advisory is an advisory of a package joined with the details of its vulnerability.
+type Advisory struct {
+	VulnerabilityID string `json:"vulnerability_id"`
+	Platform        string `json:"platform"`
+	Segment         string `json:"segment"`
+	Package         string `json:"package"`
+	FixedVersion    string `json:"fixed_version"`
+	Status          string `json:"status"`
+	// Severity is the severity of the advisory, or the one of the vulnerability if the source does not rate it.
+	Severity    string   `json:"severity"`
+	Title       string   `json:"title"`
+	CVSSV3Score *float64 `json:"cvss_v3_score"`
+}
+
+// QueryAdvisories returns the advisories matching filter in the tables of the datasource created by Migrate.
+func QueryAdvisories(ctx context.Context, dsn string, tableNames drivers.TableNames, filter AdvisoryFilter) ([]Advisory, error) {
+	if filter == (AdvisoryFilter{}) {
+		return nil, errors.New("no condition of advisories to query")
+	}
+	if _, _, ok := dumpDSN(dsn); ok {
+		return nil, fmt.Errorf("datasource '%s' can not be queried", dsn)
+	}
+	if d, _, ok := fileDSN(dsn); ok {
+		return nil, fmt.Errorf("driver '%s' does not support query", d)
+	}
+	db, d, err := dbOpen(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for _, c := range []struct {
+		column string
+		value  string
+	}{
+		{"a.platform", filter.Platform},
+		{"a.segment", filter.Segment},
+		{"a.package", filter.Package},
+		{"a.vulnerability_id", filter.VulnerabilityID},
+	} {
+		if c.value == "" {
+			continue
+		}
+		args = append(args, c.value)
+		conds = append(conds, fmt.Sprintf("%s = %s", c.column, placeholder(d, len(args))))
+	}
+	stmt := fmt.Sprintf(`SELECT a.vulnerability_id, a.platform, a.segment, a.package, a.fixed_version, a.status, a.severity,
+COALESCE(v.severity, ''), COALESCE(v.title, ''), v.cvss_v3_score
+FROM %s a LEFT JOIN %s v ON v.vulnerability_id = a.vulnerability_id
+WHERE %s ORDER BY a.vulnerability_id, a.platform, a.segment, a.package`, tableNames.Advisories, tableNames.Vulnerabilities, strings.Join(conds, " AND ")) //nolint:gosec
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var advisories []Advisory
+	for rows.Next() {
+		var (
+			a            Advisory
+			vulnSeverity string
+			score        sql.NullFloat64
+		)
+		if err := rows.Scan(&a.VulnerabilityID, &a.Platform, &a.Segment, &a.Package, &a.FixedVersion, &a.Status, &a.Severity,
+			&vulnSeverity, &a.Title, &score); err != nil {
+			return nil, err
+		}
+		if a.Severity == "" {
+			a.Severity = vulnSeverity
+		}
+		if score.Valid {
+			a.CVSSV3Score = &score.Float64
+		}
+		advisories = append(advisories, a)
+	}
+	return advisories, rows.Err()
+}
+
+// placeholder returns the n-th placeholder of the driver d.
+func placeholder(d string, n int) string {
+	if d == "mysql" {
+		return "?"
+	}
+	return "$" + strconv.Itoa(n)
+}
+
+// WriteAdvisories writes advisories in format.
+func WriteAdvisories(w io.Writer, advisories []Advisory, format string) error {
+	switch format {
+	case QueryFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VULNERABILITY\tPLATFORM\tSEGMENT\tPACKAGE\tFIXED VERSION\tSTATUS\tSEVERITY\tCVSS V3\tTITLE")
+		for _, a := range advisories {
+			score := ""
+			if a.CVSSV3Score != nil {
+				score = strconv.FormatFloat(*a.CVSSV3Score, 'f', 1, 64)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.VulnerabilityID, a.Platform, a.Segment, a.Package,
+				orDash(a.FixedVersion), orDash(a.Status), orDash(a.Severity), orDash(score), strings.ReplaceAll(a.Title, "\n", " "))
+		}
+		return tw.Flush()
+	case QueryFormatJSON:
+		if advisories == nil {
+			advisories = []Advisory{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(advisories)
+	default:
+		return fmt.Errorf("unsupported query format '%s'", format)
+	}
+}
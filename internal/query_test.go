@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestQueryAdvisories(t *testing.T) {
+	ctx := context.Background()
+	path := writeTrivyDB(t,
+		map[string]string{"CVE-2023-0001": `{"Title":"openssl: bad thing","Severity":"HIGH","CVSS":{"nvd":{"V3Vector":"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H","V3Score":9.8}}}`},
+		map[string]map[string]map[string]string{
+			"debian 12": {"openssl": {"CVE-2023-0001": `{"FixedVersion":"3.0.9-1","Status":3}`}, "curl": {"CVE-2023-0001": `{"Status":3,"Severity":2}`}},
+		})
+	tableNames := drivers.TableNames{
+		Vulnerabilities: "vulnerabilities",
+		Advisories:      "vulnerability_advisories",
+		DataSource:      "data_source",
+		Metadata:        "metadata",
+		CVSS:            "vulnerability_cvss",
+		References:      "vulnerability_references",
+		CWEs:            "vulnerability_cwes",
+	}
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "target.db")
+	if err := InitDB(ctx, dsn, tableNames); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateDB(ctx, path, dsn, tableNames, nil, LoadModeSwap, drivers.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := QueryAdvisories(ctx, dsn, tableNames, AdvisoryFilter{Platform: "debian", Segment: "12", Package: "openssl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %+v, want 1 advisory", got)
+	}
+	if a := got[0]; a.VulnerabilityID != "CVE-2023-0001" || a.FixedVersion != "3.0.9-1" || a.Severity != "HIGH" ||
+		a.Title != "openssl: bad thing" || a.CVSSV3Score == nil || *a.CVSSV3Score != 9.8 {
+		t.Errorf("got %+v", a)
+	}
+
+	got, err = QueryAdvisories(ctx, dsn, tableNames, AdvisoryFilter{VulnerabilityID: "CVE-2023-0001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Package != "curl" || got[1].Package != "openssl" {
+		t.Fatalf("got %+v, want the advisories of curl and openssl", got)
+	}
+	// the severity of the source precedes the one of the vulnerability
+	if got[0].Severity != "MEDIUM" {
+		t.Errorf("got severity %s, want MEDIUM", got[0].Severity)
+	}
+}
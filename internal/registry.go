@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aquasecurity/trivy/pkg/fanal/types"
+	"github.com/aquasecurity/trivy/pkg/log"
+	"github.com/aquasecurity/trivy/pkg/oci"
+	"github.com/docker/cli/cli/config"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// RegistryOptions configures how Trivy DB is fetched from OCI registries.
+type RegistryOptions struct {
+	// Repositories are tried in order until a download succeeds. A tag of the DB schema version is added
+	// to repositories without a tag or digest.
+	Repositories []string
+	Username     string
+	Password     string
+	// DockerConfig is a directory holding config.json. $DOCKER_CONFIG or ~/.docker is used if empty.
+	DockerConfig string
+	Insecure     bool
+	// CACert is a PEM file of CA certificates trusted in addition to the system ones.
+	CACert string
+}
+
+// repositoryRef returns repo with the tag of the DB schema version unless it has a tag or digest.
+func repositoryRef(repo string, schemaVersion int) string {
+	last := repo[strings.LastIndex(repo, "/")+1:]
+	if strings.ContainsAny(last, ":@") {
+		return repo
+	}
+	return fmt.Sprintf("%s:%d", repo, schemaVersion)
+}
+
+// tryRepositories calls fn with repos in order until it succeeds.
+func tryRepositories(repos []string, fn func(repo string) error) error {
+	var errs []error
+	for _, repo := range repos {
+		err := fn(repo)
+		if err == nil {
+			return nil
+		}
+		log.Logger.Warnf("Failed to download from %s: %s", repo, err)
+		errs = append(errs, fmt.Errorf("%s: %w", repo, err))
+	}
+	return errors.Join(errs...)
+}
+
+// newArtifact returns the OCI artifact of ref fetched with opts.
+func newArtifact(ctx context.Context, ref string, quiet bool, opts RegistryOptions) (*oci.Artifact, error) {
+	r, err := parseReference(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := transport(opts)
+	if err != nil {
+		return nil, err
+	}
+	img, err := remote.Image(r, remote.WithContext(ctx), remote.WithTransport(t), keychainOption(opts))
+	if err != nil {
+		return nil, err
+	}
+	return oci.NewArtifact(ref, quiet, types.RemoteOptions{Insecure: opts.Insecure}, oci.WithImage(img))
+}
+
+// parseReference parses ref. An insecure registry may be served over plain HTTP.
+func parseReference(ref string, opts RegistryOptions) (name.Reference, error) {
+	nameOpts := []name.Option{name.WeakValidation}
+	if opts.Insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	return name.ParseReference(ref, nameOpts...)
+}
+
+func transport(opts RegistryOptions) (http.RoundTripper, error) {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.Insecure} //nolint:gosec
+	if opts.CACert == "" {
+		return t, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pem, err := os.ReadFile(opts.CACert)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", opts.CACert)
+	}
+	t.TLSClientConfig.RootCAs = pool
+	return t, nil
+}
+
+func keychainOption(opts RegistryOptions) remote.Option {
+	switch {
+	case opts.Username != "" || opts.Password != "":
+		return remote.WithAuth(&authn.Basic{Username: opts.Username, Password: opts.Password})
+	case opts.DockerConfig != "":
+		return remote.WithAuthFromKeychain(dockerConfigKeychain{dir: opts.DockerConfig})
+	default:
+		return remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	}
+}
+
+// dockerConfigKeychain resolves credentials from config.json in dir.
+type dockerConfigKeychain struct {
+	dir string
+}
+
+func (k dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := config.Load(k.dir)
+	if err != nil {
+		return nil, err
+	}
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = authn.DefaultAuthKey
+	}
+	cfg, err := cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Username == "" && cfg.Password == "" && cfg.Auth == "" && cfg.IdentityToken == "" && cfg.RegistryToken == "" {
+		log.Logger.Debugf("No credentials for %s in %s", key, k.dir)
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
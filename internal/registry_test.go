@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	db2 "github.com/aquasecurity/trivy-db/pkg/db"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestRepositoryRef(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"ghcr.io/aquasecurity/trivy-db", "ghcr.io/aquasecurity/trivy-db:2"},
+		{"mirror.example.com:5000/trivy-db", "mirror.example.com:5000/trivy-db:2"},
+		{"mirror.example.com:5000/trivy-db:latest", "mirror.example.com:5000/trivy-db:latest"},
+		{"mirror.example.com/trivy-db@sha256:0123", "mirror.example.com/trivy-db@sha256:0123"},
+	}
+	for _, tt := range tests {
+		if got := repositoryRef(tt.repo, 2); got != tt.want {
+			t.Errorf("repositoryRef(%s) = %s, want %s", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		opts RegistryOptions
+		want string
+	}{
+		{RegistryOptions{}, "https"},
+		{RegistryOptions{Insecure: true}, "http"},
+	}
+	for _, tt := range tests {
+		r, err := parseReference("mirror.example.com:5000/trivy-db:2", tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Context().Scheme(); got != tt.want {
+			t.Errorf("insecure %v: got scheme %s, want %s", tt.opts.Insecure, got, tt.want)
+		}
+	}
+}
+
+func TestFetchTrivyDB(t *testing.T) {
+	srv := httptest.NewTLSServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "https://")
+	repo := host + "/trivy-db"
+
+	layer := static.NewLayer(tarball(t, true, map[string][]byte{
+		trivyDBFile:  []byte("db"),
+		metadataFile: []byte(fmt.Sprintf(`{"Version":%d}`, db2.SchemaVersion)),
+	}), types.MediaType("application/vnd.aquasec.trivy.db.layer.v1.tar+gzip"))
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       layer,
+		Annotations: map[string]string{"org.opencontainers.image.title": "db.tar.gz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.ParseReference(repositoryRef(repo, db2.SchemaVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img, remote.WithTransport(srv.Client().Transport)); err != nil {
+		t.Fatal(err)
+	}
+	plain := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(plain.Close)
+	plainRepo := strings.TrimPrefix(plain.URL, "http://") + "/trivy-db"
+	plainRef, err := name.ParseReference(repositoryRef(plainRepo, db2.SchemaVersion), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(plainRef, img); err != nil {
+		t.Fatal(err)
+	}
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    RegistryOptions
+		wantErr bool
+	}{
+		{"CA certificate", RegistryOptions{Repositories: []string{repo}, CACert: caCert}, false},
+		{"insecure", RegistryOptions{Repositories: []string{repo}, Insecure: true}, false},
+		{"insecure http", RegistryOptions{Repositories: []string{plainRepo}, Insecure: true}, false},
+		{"untrusted", RegistryOptions{Repositories: []string{repo}}, true},
+		{"fallback", RegistryOptions{Repositories: []string{host + "/missing", repo}, CACert: caCert}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			err := FetchTrivyDB(context.Background(), cacheDir, false, true, false, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			b, err := os.ReadFile(TrivyDBPath(cacheDir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "db" {
+				t.Errorf("got %s, want db", b)
+			}
+		})
+	}
+}
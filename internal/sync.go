@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/aquasecurity/trivy/pkg/log"
+	"github.com/k1LoW/trivy-db-to/drivers"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// syncTables writes only the rows whose content hash differs from the one stored in the tables.
+// It returns the row counts per table. If changes is not nil, the changes of the advisories are recorded.
+func syncTables(ctx context.Context, trivyDb *bolt.DB, syncer drivers.Syncer, sourceRe []*regexp.Regexp,
+	tableNames drivers.TableNames, changes *changeLog) (map[string]int, error) {
+	counts := map[string]int{}
+	if err := trivyDb.View(func(tx *bolt.Tx) error {
+		log.Logger.Infof("Syncing table '%s' ...", tableNames.Vulnerabilities)
+		hashes, err := syncer.VulnHashes(ctx)
+		if err != nil {
+			return err
+		}
+		w := &vulnWriter{syncer: syncer, children: syncVulnChildren(syncer, tableNames)}
+		d := &differ{hashes: hashes, insert: w.insert, update: w.update, delete: w.delete}
+		if err := walkVulns(tx, func(vulns [][][]byte) error {
+			counts[tableNames.Vulnerabilities] += len(vulns)
+			for _, c := range w.children {
+				rows, err := c.rows(vulns)
+				if err != nil {
+					return err
+				}
+				counts[c.table] += len(rows)
+			}
+			for _, vuln := range vulns {
+				if err := d.add(ctx, drivers.Key(string(vuln[0])), vuln, vuln[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := d.finish(ctx, tableNames.Vulnerabilities); err != nil {
+			return err
+		}
+
+		log.Logger.Infof("Syncing table '%s' ...", tableNames.DataSource)
+		hashes, err = syncer.DataSourceHashes(ctx)
+		if err != nil {
+			return err
+		}
+		d = &differ{hashes: hashes, insert: syncer.InsertDataSource, update: syncer.UpdateDataSource, delete: syncer.DeleteDataSource}
+		if err := walkDataSources(tx, func(dataSources [][][]byte) error {
+			counts[tableNames.DataSource] += len(dataSources)
+			for _, dataSource := range dataSources {
+				if err := d.add(ctx, drivers.Key(string(dataSource[0])), dataSource, dataSource[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := d.finish(ctx, tableNames.DataSource); err != nil {
+			return err
+		}
+
+		log.Logger.Infof("Syncing table '%s' ...", tableNames.Advisories)
+		hashes, err = syncer.VulnAdvisoryHashes(ctx)
+		if err != nil {
+			return err
+		}
+		d = &differ{hashes: hashes, insert: syncer.InsertVulnAdvisory, update: syncer.UpdateVulnAdvisory, delete: syncer.DeleteVulnAdvisories}
+		if changes != nil {
+			d.insert = changes.record(ChangeAdded, d.insert)
+			d.update = changes.record(ChangeModified, d.update)
+			d.delete = changes.record(ChangeRemoved, d.delete)
+		}
+		if err := walkAdvisories(tx, sourceRe, func(secAdv [][][]byte) error {
+			counts[tableNames.Advisories] += len(secAdv)
+			for _, a := range secAdv {
+				key := drivers.Key(string(a[0]), string(a[1]), string(a[2]), string(a[3]))
+				if err := d.add(ctx, key, a, a[4]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := d.finish(ctx, tableNames.Advisories); err != nil {
+			return err
+		}
+		if changes == nil {
+			return nil
+		}
+		return changes.flush(ctx, 0)
+	}); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// vulnWriter writes vulnerabilities with the rows of their child tables.
+// Child rows are derived from the value, so they are replaced whenever the vulnerability changes.
+type vulnWriter struct {
+	syncer   drivers.Syncer
+	children []vulnChild
+}
+
+func syncVulnChildren(syncer drivers.Syncer, tableNames drivers.TableNames) []vulnChild {
+	return []vulnChild{
+		{tableNames.CVSS, drivers.CVSSRows, syncer.InsertVulnCVSS, syncer.DeleteVulnCVSS},
+		{tableNames.References, drivers.ReferenceRows, syncer.InsertVulnReferences, syncer.DeleteVulnReferences},
+		{tableNames.CWEs, drivers.CWERows, syncer.InsertVulnCWEs, syncer.DeleteVulnCWEs},
+	}
+}
+
+func (w *vulnWriter) insert(ctx context.Context, vulns [][][]byte) error {
+	if err := w.syncer.InsertVuln(ctx, vulns); err != nil {
+		return err
+	}
+	return w.insertChildren(ctx, vulns)
+}
+
+func (w *vulnWriter) update(ctx context.Context, vulns [][][]byte) error {
+	if err := w.syncer.UpdateVuln(ctx, vulns); err != nil {
+		return err
+	}
+	if err := w.deleteChildren(ctx, vulns); err != nil {
+		return err
+	}
+	return w.insertChildren(ctx, vulns)
+}
+
+func (w *vulnWriter) delete(ctx context.Context, vulns [][][]byte) error {
+	if err := w.syncer.DeleteVulns(ctx, vulns); err != nil {
+		return err
+	}
+	return w.deleteChildren(ctx, vulns)
+}
+
+func (w *vulnWriter) insertChildren(ctx context.Context, vulns [][][]byte) error {
+	for _, c := range w.children {
+		rows, err := c.rows(vulns)
+		if err != nil {
+			return err
+		}
+		if err := c.insert(ctx, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *vulnWriter) deleteChildren(ctx context.Context, vulns [][][]byte) error {
+	for _, c := range w.children {
+		if err := c.delete(ctx, vulns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// differ compares rows with the content hashes stored in a table and writes the changed rows in chunks.
+type differ struct {
+	hashes map[string]string
+	insert func(ctx context.Context, rows [][][]byte) error
+	update func(ctx context.Context, rows [][][]byte) error
+	delete func(ctx context.Context, rows [][][]byte) error
+
+	inserts  [][][]byte
+	updates  [][][]byte
+	inserted int
+	updated  int
+}
+
+func (d *differ) add(ctx context.Context, key string, row [][]byte, value []byte) error {
+	hash, ok := d.hashes[key]
+	delete(d.hashes, key)
+	switch {
+	case !ok:
+		d.inserts = append(d.inserts, row)
+	case hash != drivers.Hash(value):
+		d.updates = append(d.updates, row)
+	}
+	return d.flush(ctx, chunkSize)
+}
+
+func (d *differ) flush(ctx context.Context, size int) error {
+	if len(d.inserts) > 0 && len(d.inserts) >= size {
+		if err := d.insert(ctx, d.inserts); err != nil {
+			return err
+		}
+		d.inserted += len(d.inserts)
+		d.inserts = nil
+	}
+	if len(d.updates) > 0 && len(d.updates) >= size {
+		if err := d.update(ctx, d.updates); err != nil {
+			return err
+		}
+		d.updated += len(d.updates)
+		d.updates = nil
+	}
+	return nil
+}
+
+// finish writes the remaining rows and deletes the rows that no longer exist in Trivy DB.
+func (d *differ) finish(ctx context.Context, table string) error {
+	if err := d.flush(ctx, 0); err != nil {
+		return err
+	}
+	var deletes [][][]byte
+	for key := range d.hashes {
+		deletes = append(deletes, drivers.SplitKey(key))
+	}
+	for _, c := range lo.Chunk(deletes, chunkSize) {
+		if err := d.delete(ctx, c); err != nil {
+			return err
+		}
+	}
+	log.Logger.Infof("Table '%s': %d inserted, %d updated, %d deleted", table, d.inserted, d.updated, len(deletes))
+	return nil
+}
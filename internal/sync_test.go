@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/k1LoW/trivy-db-to/drivers"
+)
+
+func TestDiffer(t *testing.T) {
+	ctx := context.Background()
+	var inserted, updated, deleted []string
+	record := func(dst *[]string) func(ctx context.Context, rows [][][]byte) error {
+		return func(ctx context.Context, rows [][][]byte) error {
+			for _, r := range rows {
+				*dst = append(*dst, string(r[0]))
+			}
+			return nil
+		}
+	}
+	d := &differ{
+		hashes: map[string]string{
+			drivers.Key("CVE-2023-0001"): drivers.Hash([]byte(`{"Severity":"LOW"}`)),
+			drivers.Key("CVE-2023-0002"): drivers.Hash([]byte(`{"Severity":"HIGH"}`)),
+			drivers.Key("CVE-2023-0003"): drivers.Hash([]byte(`{}`)),
+		},
+		insert: record(&inserted),
+		update: record(&updated),
+		delete: record(&deleted),
+	}
+	rows := [][][]byte{
+		{[]byte("CVE-2023-0001"), []byte(`{"Severity":"LOW"}`)},
+		{[]byte("CVE-2023-0002"), []byte(`{"Severity":"CRITICAL"}`)},
+		{[]byte("CVE-2023-0004"), []byte(`{}`)},
+	}
+	for _, r := range rows {
+		if err := d.add(ctx, drivers.Key(string(r[0])), r, r[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.finish(ctx, "vulnerabilities"); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	for _, tt := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"inserted", inserted, []string{"CVE-2023-0004"}},
+		{"updated", updated, []string{"CVE-2023-0002"}},
+		{"deleted", deleted, []string{"CVE-2023-0003"}},
+	} {
+		if len(tt.got) != len(tt.want) {
+			t.Errorf("%s got %v, want %v", tt.name, tt.got, tt.want)
+			continue
+		}
+		for i := range tt.got {
+			if tt.got[i] != tt.want[i] {
+				t.Errorf("%s got %v, want %v", tt.name, tt.got, tt.want)
+			}
+		}
+	}
+}